@@ -0,0 +1,518 @@
+/* 
+** @name: engine
+** @author: Timo Kats
+** @description: Public API for embedding codis (typed results instead of display text).
+** @note: Codis keeps one index per process, opening an engine replaces the previous index (and the methods of the previous engine return ErrStale).
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	coparse "codis/lib/coparse"
+	cotypes "codis/lib/cotypes"
+	cofile "codis/lib/cofile"
+	cosearch "codis/lib/cosearch"
+	coexplore "codis/lib/coexplore"
+	coreplace "codis/lib/coreplace"
+	cosyntax "codis/lib/cosyntax"
+	cosimilar "codis/lib/cosimilar"
+	codependencies "codis/lib/codependencies"
+)
+
+// globals
+
+var lock sync.RWMutex
+var generation int
+var ErrStale = errors.New("codis: this engine's index was replaced by a newer engine (open it again)")
+
+// structs
+
+type Result = cotypes.Result
+type Hit = coreplace.Hit
+type Symbol = cotypes.Symbol
+
+type Options struct {
+	ContextLines int
+	Verbose      bool
+}
+
+type Filter struct {
+	Categories      []string
+	ExcludeComments bool
+}
+
+type TreeNode struct {
+	Path     string      `json:"path"`
+	Name     string      `json:"name"`
+	IsDir    bool        `json:"is_dir"`
+	Category string      `json:"category,omitempty"`
+	Children []*TreeNode `json:"children,omitempty"`
+}
+
+type FileOverview struct {
+	Path     string   `json:"path"`
+	Category string   `json:"category"`
+	Overview []string `json:"overview"`
+}
+
+type DependencyGraph struct {
+	Imports map[string][]string `json:"imports"`
+	Roots   []string            `json:"roots"`
+	Orphans []string            `json:"orphans"`
+}
+
+type Stats struct {
+	Files   int      `json:"files"`
+	Lines   int      `json:"lines"`
+	Skipped []string `json:"skipped,omitempty"` // the paths that couldn't be read at the last (re)index
+}
+
+type Engine struct {
+	Root       string
+	Options    Options
+	fullTree   *coexplore.Node
+	generation int // the index of the engine is current while this equals the global generation
+	skipped    []string
+}
+
+/* 
+** @name: Open
+** @description: Parses a directory and returns an engine to query it. Paths that can't be read are skipped, the engine is then returned together with an error that lists them.
+*/
+func Open(root string, opts Options) (*Engine, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(absRoot); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("codis: %s is not a directory", absRoot)
+	}
+	lock.Lock()
+	generation++
+	e := &Engine{Root: absRoot, Options: opts, generation: generation}
+	lock.Unlock()
+	return e, e.Reindex()
+}
+
+/* 
+** @name: stale
+** @description: Returns ErrStale if another engine was opened after this one (call it while holding the lock).
+*/
+func (e *Engine) stale() error {
+	if e.generation != generation {
+		return ErrStale
+	}
+	return nil
+}
+
+/* 
+** @name: Reindex
+** @description: Parses the root directory again (blocks all queries while doing so). Paths that can't be read are skipped and returned as the error, the rest is indexed.
+*/
+func (e *Engine) Reindex() error {
+	lock.Lock()
+	defer lock.Unlock()
+	if err := e.stale(); err != nil {
+		return err
+	}
+	coparse.Verbose = e.Options.Verbose
+	if e.Options.ContextLines > 0 {
+		cosearch.SetContext(e.Options.ContextLines)
+	}
+	walkErr := coparse.Reindex(e.Root)
+	e.skipped = []string{}
+	if walkErr != nil {
+		e.skipped = strings.Split(walkErr.Error(), "\n")
+	}
+	cosimilar.Build()
+	fullTree, err := coexplore.NewTree(e.Root)
+	if fullTree != nil {
+		e.fullTree = fullTree
+	}
+	return errors.Join(walkErr, err)
+}
+
+/* 
+** @name: Stats
+** @description: Returns the number of indexed files and lines, and the paths that were skipped.
+*/
+func (e *Engine) Stats() (Stats, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if err := e.stale(); err != nil {
+		return Stats{}, err
+	}
+	return Stats{len(coparse.Categories), len(coparse.OrderedKeys), e.skipped}, nil
+}
+
+/* 
+** @name: ReindexFile
+** @description: Parses one (edited) file again, the path is absolute or relative to the root.
+*/
+func (e *Engine) ReindexFile(path string) error {
+	lock.Lock()
+	defer lock.Unlock()
+	if err := e.stale(); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(e.Root, path)
+	}
+	coparse.Verbose = false
+	coparse.ReindexFile(path)
+	cosimilar.Build()
+	coparse.Verbose = e.Options.Verbose
+	return nil
+}
+
+/* 
+** @name: FullTree
+** @description: Returns the parsed file tree (used by the TUI's explorer).
+*/
+func (e *Engine) FullTree() *coexplore.Node {
+	return e.fullTree
+}
+
+/* 
+** @name: collect
+** @description: Returns an emit function that stops when the context is cancelled.
+*/
+func collect(ctx context.Context, emit func(Result) bool) func(Result) bool {
+	return func(result Result) bool {
+		return ctx.Err() == nil && emit(result)
+	}
+}
+
+/* 
+** @name: SearchFunc
+** @description: Streams the lines that match a regular expression. Stops when emit returns false.
+*/
+func (e *Engine) SearchFunc(ctx context.Context, query string, filter Filter, emit func(Result) bool) error {
+	lock.RLock()
+	defer lock.RUnlock()
+	if err := e.stale(); err != nil {
+		return err
+	}
+	if err := cosearch.BasicSearch(query, filter.Categories, !filter.ExcludeComments, collect(ctx, emit)); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+/* 
+** @name: FuzzyFunc
+** @description: Streams the lines that (fuzzily) match a query. Stops when emit returns false.
+*/
+func (e *Engine) FuzzyFunc(ctx context.Context, query string, filter Filter, emit func(Result) bool) error {
+	lock.RLock()
+	defer lock.RUnlock()
+	if err := e.stale(); err != nil {
+		return err
+	}
+	if err := cosearch.FuzzySearch(query, filter.Categories, !filter.ExcludeComments, collect(ctx, emit)); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+/* 
+** @name: StructuralFunc
+** @description: Streams the lines of the Go files where code with the shape of a pattern starts ($name and ... are placeholders). Stops when emit returns false.
+*/
+func (e *Engine) StructuralFunc(ctx context.Context, query string, filter Filter, emit func(Result) bool) error {
+	lock.RLock()
+	defer lock.RUnlock()
+	if err := e.stale(); err != nil {
+		return err
+	}
+	if err := cosyntax.Search(query, filter.Categories, collect(ctx, emit)); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+/* 
+** @name: SimilarFunc
+** @description: Streams the code regions that are the most similar to a snippet (or to the code around @path:line), the most similar first. Stops when emit returns false.
+*/
+func (e *Engine) SimilarFunc(ctx context.Context, query string, filter Filter, emit func(Result) bool) error {
+	lock.RLock()
+	defer lock.RUnlock()
+	if err := e.stale(); err != nil {
+		return err
+	}
+	if err := cosimilar.Search(query, filter.Categories, collect(ctx, emit)); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+/* 
+** @name: RefineFunc
+** @description: Streams the results of a previous search that also match a regular expression (or a fuzzy query). Stops when emit returns false.
+*/
+func (e *Engine) RefineFunc(ctx context.Context, query string, fuzzy bool, results []Result, emit func(Result) bool) error {
+	lock.RLock()
+	defer lock.RUnlock()
+	if err := e.stale(); err != nil {
+		return err
+	}
+	if err := cosearch.RefineSearch(query, fuzzy, results, collect(ctx, emit)); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+/* 
+** @name: PlanReplace
+** @description: Returns the lines of the results that change when the matches of a regular expression are replaced.
+*/
+func (e *Engine) PlanReplace(query string, replacement string, results []Result) ([]Hit, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if err := e.stale(); err != nil {
+		return nil, err
+	}
+	return coreplace.Plan(query, replacement, results)
+}
+
+/* 
+** @name: ApplyReplace
+** @description: Writes the accepted hits to their files and parses the written files again. Files modified since indexing are skipped.
+*/
+func (e *Engine) ApplyReplace(hits []Hit) ([]string, []error) {
+	lock.RLock()
+	if err := e.stale(); err != nil {
+		lock.RUnlock()
+		return []string{}, []error{err}
+	}
+	written, errs := coreplace.Apply(hits)
+	lock.RUnlock()
+	for _, path := range written {
+		e.ReindexFile(path)
+	}
+	return written, errs
+}
+
+/* 
+** @name: ReplacePatch
+** @description: Returns the accepted hits as a unified diff. Files modified since indexing are skipped.
+*/
+func (e *Engine) ReplacePatch(hits []Hit) (string, []error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if err := e.stale(); err != nil {
+		return "", []error{err}
+	}
+	return coreplace.Patch(hits)
+}
+
+/* 
+** @name: SymbolFunc
+** @description: Streams the declarations of functions/objects whose name contains the query.
+*/
+func (e *Engine) SymbolFunc(ctx context.Context, query string, emit func(Result) bool) error {
+	lock.RLock()
+	defer lock.RUnlock()
+	if err := e.stale(); err != nil {
+		return err
+	}
+	cosearch.SymbolSearch(query, collect(ctx, emit))
+	return ctx.Err()
+}
+
+/* 
+** @name: gather
+** @description: Collects the results of a streaming function in a slice.
+*/
+func gather(stream func(func(Result) bool) error) ([]Result, error) {
+	results := []Result{}
+	err := stream(func(result Result) bool {
+		results = append(results, result)
+		return true
+	})
+	return results, err
+}
+
+/* 
+** @name: Search
+** @description: Returns the lines that match a regular expression.
+*/
+func (e *Engine) Search(ctx context.Context, query string, filter Filter) ([]Result, error) {
+	return gather(func(emit func(Result) bool) error { return e.SearchFunc(ctx, query, filter, emit) })
+}
+
+/* 
+** @name: Fuzzy
+** @description: Returns the lines that (fuzzily) match a query.
+*/
+func (e *Engine) Fuzzy(ctx context.Context, query string, filter Filter) ([]Result, error) {
+	return gather(func(emit func(Result) bool) error { return e.FuzzyFunc(ctx, query, filter, emit) })
+}
+
+/* 
+** @name: Structural
+** @description: Returns the lines of the Go files where code with the shape of a pattern starts.
+*/
+func (e *Engine) Structural(ctx context.Context, query string, filter Filter) ([]Result, error) {
+	return gather(func(emit func(Result) bool) error { return e.StructuralFunc(ctx, query, filter, emit) })
+}
+
+/* 
+** @name: Similar
+** @description: Returns the code regions that are the most similar to a snippet (or to the code around @path:line).
+*/
+func (e *Engine) Similar(ctx context.Context, query string, filter Filter) ([]Result, error) {
+	return gather(func(emit func(Result) bool) error { return e.SimilarFunc(ctx, query, filter, emit) })
+}
+
+/* 
+** @name: SymbolResults
+** @description: Returns the declarations of functions/objects whose name contains the query as results.
+*/
+func (e *Engine) SymbolResults(ctx context.Context, query string) ([]Result, error) {
+	return gather(func(emit func(Result) bool) error { return e.SymbolFunc(ctx, query, emit) })
+}
+
+/* 
+** @name: relativePath
+** @description: Returns a path relative to the root directory.
+*/
+func (e *Engine) relativePath(path string) string {
+	if relativePath, err := filepath.Rel(e.Root, path); err == nil {
+		return relativePath
+	}
+	return path
+}
+
+/* 
+** @name: Symbols
+** @description: Returns the functions and objects whose name contains the query (paths are relative to the root).
+*/
+func (e *Engine) Symbols(ctx context.Context, query string) ([]Symbol, error) {
+	results, err := e.SymbolResults(ctx, query)
+	symbols := []Symbol{}
+	lock.RLock()
+	defer lock.RUnlock()
+	symbolsByIndex := make(map[int]Symbol)
+	for _, symbol := range coparse.Symbols {
+		symbolsByIndex[symbol.Index] = symbol
+	}
+	for _, result := range results {
+		symbol := symbolsByIndex[result.Index]
+		symbol.FilePath = e.relativePath(symbol.FilePath)
+		symbols = append(symbols, symbol)
+	}
+	return symbols, err
+}
+
+/* 
+** @name: Line
+** @description: Returns the contents of a line (1-based) of an indexed file (path is absolute or relative to the root).
+*/
+func (e *Engine) Line(path string, linenumber int) (string, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if e.stale() != nil {
+		return "", false
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(e.Root, path)
+	}
+	for _, key := range coparse.OrderedKeys {
+		if key.FilePath == path && key.Linenumber == linenumber {
+			return coparse.LabeledRows[key], true
+		}
+	}
+	return "", false
+}
+
+/* 
+** @name: newTreeNode
+** @description: Converts (part of) the file tree to a typed node.
+*/
+func (e *Engine) newTreeNode(ctx context.Context, node *coexplore.Node, currentLevel int, maxLevel int) *TreeNode {
+	treeNode := &TreeNode{
+		Path: e.relativePath(node.FullPath),
+		Name: node.Info.Name,
+		IsDir: node.Info.IsDir,
+		Category: coparse.Categories[node.FullPath],
+	}
+	if currentLevel < maxLevel && ctx.Err() == nil {
+		for _, child := range node.Children {
+			if child.Info.Name != ".git" {
+				treeNode.Children = append(treeNode.Children, e.newTreeNode(ctx, child, currentLevel+1, maxLevel))
+			}
+		}
+	}
+	return treeNode
+}
+
+/* 
+** @name: Tree
+** @description: Returns the file tree up to a max depth.
+*/
+func (e *Engine) Tree(ctx context.Context, maxLevel int) (*TreeNode, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if err := e.stale(); err != nil {
+		return nil, err
+	}
+	treeNode := e.newTreeNode(ctx, e.fullTree, 0, maxLevel)
+	return treeNode, ctx.Err()
+}
+
+/* 
+** @name: Dependencies
+** @description: Returns the imports between files, the root files and the orphan files.
+*/
+func (e *Engine) Dependencies(ctx context.Context) (DependencyGraph, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if err := e.stale(); err != nil {
+		return DependencyGraph{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return DependencyGraph{}, err
+	}
+	return DependencyGraph{coparse.Imports, codependencies.GetRootFiles(), codependencies.GetOrphanFiles()}, nil
+}
+
+/* 
+** @name: FilesFunc
+** @description: Streams a result (line 1) for every file whose name contains the query.
+*/
+func (e *Engine) FilesFunc(ctx context.Context, query string, filter Filter, emit func(Result) bool) error {
+	lock.RLock()
+	defer lock.RUnlock()
+	if err := e.stale(); err != nil {
+		return err
+	}
+	cofile.Search(query, filter.Categories, collect(ctx, emit))
+	return ctx.Err()
+}
+
+/* 
+** @name: FileOverview
+** @description: Returns the overview (functions, imports, fields or preview) of the files that match the query.
+*/
+func (e *Engine) FileOverview(ctx context.Context, query string, filter Filter) ([]FileOverview, error) {
+	files, err := gather(func(emit func(Result) bool) error { return e.FilesFunc(ctx, query, filter, emit) })
+	overviews := []FileOverview{}
+	lock.RLock()
+	defer lock.RUnlock()
+	for _, file := range files {
+		overviews = append(overviews, FileOverview{file.Path, file.Labels[0], coparse.FileOverview[file.Text]})
+	}
+	return overviews, err
+}
@@ -0,0 +1,258 @@
+/* 
+** @name: cobookmarks
+** @author: Timo Kats
+** @description: Bookmarked locations (file, line and note) of a project, stored next to its history.
+*/
+
+package cobookmarks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	coparse "codis/lib/coparse"
+	cohistory "codis/lib/cohistory"
+)
+
+// globals
+
+var Bookmarks []Bookmark
+var ExportFormats = []string{"markdown", "quickfix"}
+
+// structs
+
+type Bookmark struct {
+	Path  string `json:"path"` // relative to the project
+	Line  int    `json:"line"`
+	Text  string `json:"text"` // contents of the line, used to find it again when lines shift
+	Note  string `json:"note,omitempty"`
+	Stale bool   `json:"-"` // the line can't be found anymore
+}
+
+/* 
+** @name: save
+** @description: Stores the bookmarks of the project.
+*/
+func save() error {
+	content, err := json.MarshalIndent(Bookmarks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return cohistory.WriteFile("bookmarks.json", append(content, '\n'))
+}
+
+/* 
+** @name: Open
+** @description: Loads the bookmarks of the project that is opened in cohistory and anchors them in the index.
+*/
+func Open() error {
+	Bookmarks = []Bookmark{}
+	directory := cohistory.Directory(cohistory.Project)
+	if directory == "" {
+		return nil
+	}
+	content, err := os.ReadFile(filepath.Join(directory, "bookmarks.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, &Bookmarks); err != nil {
+		Bookmarks = []Bookmark{}
+		return fmt.Errorf("%s: %v", filepath.Join(directory, "bookmarks.json"), err)
+	}
+	return Reanchor()
+}
+
+/* 
+** @name: fileLines
+** @description: Returns the indexed lines of the bookmarked files (by relative path).
+*/
+func fileLines() map[string][]string {
+	lines := make(map[string][]string)
+	for _, bookmark := range Bookmarks {
+		lines[bookmark.Path] = nil
+	}
+	for _, key := range coparse.OrderedKeys {
+		path, err := filepath.Rel(coparse.CurrentDirectory, key.FilePath)
+		if err != nil {
+			continue
+		}
+		if _, ok := lines[path]; ok {
+			lines[path] = append(lines[path], coparse.LabeledRows[key])
+		}
+	}
+	return lines
+}
+
+/* 
+** @name: anchor
+** @description: Returns the line number of the line with the text that is the closest to the old line number (or 0).
+*/
+func anchor(lines []string, linenumber int, text string) int {
+	if linenumber >= 1 && linenumber <= len(lines) && lines[linenumber-1] == text {
+		return linenumber
+	}
+	for _, same := range []func(string) bool{
+		func(line string) bool { return line == text },
+		func(line string) bool { return strings.TrimSpace(line) == strings.TrimSpace(text) && strings.TrimSpace(text) != "" },
+	} {
+		for distance := 1; distance <= len(lines); distance++ {
+			for _, candidate := range []int{linenumber - distance, linenumber + distance} {
+				if candidate >= 1 && candidate <= len(lines) && same(lines[candidate-1]) {
+					return candidate
+				}
+			}
+		}
+	}
+	return 0
+}
+
+/* 
+** @name: Reanchor
+** @description: Moves the bookmarks to the line with their text when the lines of a file have shifted (after re-indexing).
+*/
+func Reanchor() error {
+	lines := fileLines()
+	changed := false
+	for index, bookmark := range Bookmarks {
+		linenumber := anchor(lines[bookmark.Path], bookmark.Line, bookmark.Text)
+		Bookmarks[index].Stale = linenumber == 0
+		if linenumber != 0 && linenumber != bookmark.Line {
+			Bookmarks[index].Line, changed = linenumber, true
+		}
+	}
+	if changed {
+		return save()
+	}
+	return nil
+}
+
+/* 
+** @name: New
+** @description: Returns a bookmark for a line of an indexed file (the path is absolute).
+*/
+func New(filePath string, linenumber int) Bookmark {
+	path, err := filepath.Rel(coparse.CurrentDirectory, filePath)
+	if err != nil {
+		path = filePath
+	}
+	bookmark := Bookmark{Path: path, Line: linenumber}
+	for _, key := range coparse.OrderedKeys {
+		if key.FilePath == filePath && key.Linenumber == linenumber {
+			bookmark.Text = coparse.LabeledRows[key]
+			break
+		}
+	}
+	return bookmark
+}
+
+/* 
+** @name: Add
+** @description: Adds a bookmark (a bookmark on the same line gets the new note instead).
+*/
+func Add(bookmark Bookmark) error {
+	for index, existing := range Bookmarks {
+		if existing.Path == bookmark.Path && existing.Line == bookmark.Line {
+			Bookmarks[index].Note = bookmark.Note
+			return save()
+		}
+	}
+	Bookmarks = append(Bookmarks, bookmark)
+	return save()
+}
+
+/* 
+** @name: Remove
+** @description: Removes a bookmark by its number (1-based).
+*/
+func Remove(number int) error {
+	if number < 1 || number > len(Bookmarks) {
+		return fmt.Errorf("there is no bookmark %d", number)
+	}
+	Bookmarks = append(Bookmarks[:number-1], Bookmarks[number:]...)
+	return save()
+}
+
+/* 
+** @name: SetNote
+** @description: Changes the note of a bookmark by its number (1-based).
+*/
+func SetNote(number int, note string) error {
+	if number < 1 || number > len(Bookmarks) {
+		return fmt.Errorf("there is no bookmark %d", number)
+	}
+	Bookmarks[number-1].Note = note
+	return save()
+}
+
+/* 
+** @name: Location
+** @description: Returns the absolute path and line of a bookmark.
+*/
+func Location(bookmark Bookmark) (string, int) {
+	if filepath.IsAbs(bookmark.Path) {
+		return bookmark.Path, bookmark.Line
+	}
+	return filepath.Join(coparse.CurrentDirectory, bookmark.Path), bookmark.Line
+}
+
+/* 
+** @name: Describe
+** @description: Returns the location and note of a bookmark, with the bookmarked line below it.
+*/
+func Describe(bookmark Bookmark) string {
+	description := fmt.Sprintf("%s:%d", bookmark.Path, bookmark.Line)
+	if bookmark.Stale {
+		description += " (line not found)"
+	}
+	if bookmark.Note != "" {
+		description += "  " + bookmark.Note
+	}
+	return description + "\n        " + strings.TrimSpace(bookmark.Text)
+}
+
+/* 
+** @name: codeSpan
+** @description: Returns text as a markdown code span.
+*/
+func codeSpan(text string) string {
+	if strings.Contains(text, "`") {
+		return "`` " + text + " ``"
+	}
+	return "`" + text + "`"
+}
+
+/* 
+** @name: Export
+** @description: Returns the bookmarks as a markdown list or in vim's quickfix format (path:line:col:text).
+*/
+func Export(format string) string {
+	exported := ""
+	if format == "markdown" {
+		exported = "# Bookmarks\n\n"
+	}
+	for _, bookmark := range Bookmarks {
+		text := strings.TrimSpace(bookmark.Text)
+		if format == "markdown" {
+			exported += fmt.Sprintf("- [%s:%d](%s#L%d)", bookmark.Path, bookmark.Line, filepath.ToSlash(bookmark.Path), bookmark.Line)
+			if bookmark.Note != "" {
+				exported += " " + bookmark.Note
+			}
+			if text != "" {
+				exported += "\n  " + codeSpan(text)
+			}
+			exported += "\n"
+		} else {
+			if bookmark.Note != "" {
+				text = bookmark.Note + " | " + text
+			}
+			exported += fmt.Sprintf("%s:%d:1:%s\n", bookmark.Path, bookmark.Line, text)
+		}
+	}
+	return exported
+}
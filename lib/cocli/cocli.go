@@ -0,0 +1,370 @@
+/* 
+** @name: cocli
+** @author: Timo Kats
+** @description: Headless subcommands that print results to stdout instead of starting the TUI.
+*/
+
+package cocli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	engine "codis/engine"
+	coparse "codis/lib/coparse"
+	coutils "codis/lib/coutils"
+	cotypes "codis/lib/cotypes"
+	cofile "codis/lib/cofile"
+	cosearch "codis/lib/cosearch"
+	colsp "codis/lib/colsp"
+	coserve "codis/lib/coserve"
+	coexplore "codis/lib/coexplore"
+	codependencies "codis/lib/codependencies"
+	cosettings "codis/lib/cosettings"
+)
+
+// globals
+
+var Subcommands = []string{"search", "fuzzy", "structural", "similar", "tree", "deps", "file", "replace", "serve", "lsp"}
+var Formats = []string{"text", "json", "ndjson", "grep", "vimgrep", "emacs"}
+
+// structs
+
+type options struct {
+	root string
+	categories string
+	comments bool
+	context int
+	before int
+	after int
+	depth int
+	dirOnly bool
+	info int
+	view int
+	format string
+	json bool
+	ndjson bool
+	addr string
+	with string
+	write bool
+}
+
+/* 
+** @name: usage
+** @description: Prints how the subcommands should be called.
+*/
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: codis [search|fuzzy|structural|similar|tree|deps|file] [flags] <query>")
+	fmt.Fprintln(os.Stderr, "       codis replace -with <replacement> [-write] [flags] <regex>")
+	fmt.Fprintln(os.Stderr, "       codis serve [-addr 127.0.0.1:7777] [-root dir]")
+	fmt.Fprintln(os.Stderr, "       codis lsp [-root dir]")
+	fmt.Fprintln(os.Stderr, "run codis without arguments to start the terminal user interface.")
+}
+
+/* 
+** @name: newFlagSet
+** @description: Returns the flags for a subcommand (these mirror the settings form).
+*/
+func newFlagSet(subcommand string, opts *options) *flag.FlagSet {
+	flags := flag.NewFlagSet(subcommand, flag.ContinueOnError)
+	flags.StringVar(&opts.root, "root", ".", "directory to index")
+	flags.StringVar(&opts.categories, "categories", "", "comma separated file categories to search in (e.g. code,data)")
+	flags.BoolVar(&opts.comments, "comments", true, "include comments in the results")
+	flags.IntVar(&opts.context, "context", -1, "lines of context around each result (default from the config, otherwise 2)")
+	flags.IntVar(&opts.before, "before", -1, "lines of context before each result (overrides -context)")
+	flags.IntVar(&opts.after, "after", -1, "lines of context after each result (overrides -context)")
+	flags.IntVar(&opts.depth, "depth", -1, "max depth of the file tree (default from the config, otherwise 5)")
+	flags.BoolVar(&opts.dirOnly, "dirs", false, "only show directories in the file tree")
+	flags.IntVar(&opts.info, "info", 0, "info box to show (0 types, 1 functions, 2 objects, 3 web domains, 5 and up custom labels)")
+	flags.IntVar(&opts.view, "view", 0, "file view to show (0 functions/fields, 1 imports/preview)")
+	flags.StringVar(&opts.format, "format", "text", "output format (" + strings.Join(Formats, ", ") + ")")
+	flags.BoolVar(&opts.json, "json", false, "shorthand for -format json")
+	flags.BoolVar(&opts.ndjson, "ndjson", false, "shorthand for -format ndjson")
+	flags.StringVar(&opts.addr, "addr", "127.0.0.1:7777", "address to listen on for serve")
+	flags.StringVar(&opts.with, "with", "", "replacement for replace ($1 and ${name} refer to groups of the regex)")
+	flags.BoolVar(&opts.write, "write", false, "write the replacements to the files instead of printing a patch")
+	return flags
+}
+
+/* 
+** @name: parseArgs
+** @description: Parses flags that can appear before or after the query.
+*/
+func parseArgs(flags *flag.FlagSet, args []string) (string, error) {
+	query := []string{}
+	for len(args) > 0 {
+		if err := flags.Parse(args); err != nil {
+			return "", err
+		}
+		args = flags.Args()
+		if len(args) > 0 {
+			query = append(query, args[0])
+			args = args[1:]
+		}
+	}
+	return strings.Join(query, " "), nil
+}
+
+/* 
+** @name: splitCategories
+** @description: Turns the comma separated categories flag into a slice.
+*/
+func splitCategories(categories string) []string {
+	contextCategories := []string{}
+	for _, category := range strings.Split(categories, ",") {
+		if strings.TrimSpace(category) != "" {
+			contextCategories = append(contextCategories, strings.TrimSpace(category))
+		}
+	}
+	return contextCategories
+}
+
+/* 
+** @name: printResults
+** @description: Writes the results and their locations to stdout.
+*/
+func printResults(results []string, locations []string) {
+	previousLocation := ""
+	for index, result := range results {
+		if locations[index] != "None" && locations[index] != previousLocation {
+			fmt.Println(locations[index])
+		}
+		previousLocation = locations[index]
+		fmt.Println(strings.Trim(result, "\n"))
+		if index < len(results) - 1 {
+			fmt.Println()
+		}
+	}
+}
+
+/* 
+** @name: newPrinter
+** @description: Returns a function that writes a structured result to stdout and one that closes the output.
+*/
+func newPrinter(format string) (func(cotypes.Result) bool, func()) {
+	count := 0
+	if format == "json" {
+		fmt.Print("[")
+	}
+	emit := func(result cotypes.Result) bool {
+		if format == "grep" || format == "vimgrep" || format == "emacs" {
+			fmt.Println(cosearch.FormatLine(result, format))
+			return true
+		}
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "codis:", err)
+			return false
+		}
+		if format == "json" && count > 0 {
+			fmt.Print(",")
+		}
+		if format == "json" {
+			fmt.Print("\n  " + string(encoded))
+		} else {
+			fmt.Println(string(encoded))
+		}
+		count += 1
+		return true
+	}
+	done := func() {
+		if format == "json" && count > 0 {
+			fmt.Println("\n]")
+		} else if format == "json" {
+			fmt.Println("]")
+		}
+	}
+	return emit, done
+}
+
+/* 
+** @name: runStructured
+** @description: Streams the results of a subcommand in a machine-readable format.
+*/
+func runStructured(e *engine.Engine, subcommand string, query string, filter engine.Filter, format string) int {
+	if subcommand == "tree" || subcommand == "deps" {
+		fmt.Fprintln(os.Stderr, "codis: -format " + format + " is only available for search, fuzzy, structural, similar and file")
+		return 2
+	}
+	emit, done := newPrinter(format)
+	defer done()
+	ctx := context.Background()
+	if subcommand == "search" {
+		if err := e.SearchFunc(ctx, query, filter, emit); err != nil {
+			fmt.Fprintln(os.Stderr, "codis: invalid query:", err)
+			return 1
+		}
+	} else if subcommand == "fuzzy" {
+		if err := e.FuzzyFunc(ctx, query, filter, emit); err != nil {
+			fmt.Fprintln(os.Stderr, "codis: invalid query:", err)
+			return 1
+		}
+	} else if subcommand == "structural" {
+		if err := e.StructuralFunc(ctx, query, filter, emit); err != nil {
+			fmt.Fprintln(os.Stderr, "codis: invalid pattern:", err)
+			return 1
+		}
+	} else if subcommand == "similar" {
+		if err := e.SimilarFunc(ctx, query, filter, emit); err != nil {
+			fmt.Fprintln(os.Stderr, "codis:", err)
+			return 1
+		}
+	} else if subcommand == "file" {
+		e.FilesFunc(ctx, query, filter, emit)
+	}
+	return 0
+}
+
+/* 
+** @name: runReplace
+** @description: Prints the replacements of the matches of a regex as a unified diff, or writes them to the files.
+*/
+func runReplace(e *engine.Engine, query string, filter engine.Filter, opts options) int {
+	results, err := e.Search(context.Background(), query, filter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "codis: invalid query:", err)
+		return 1
+	}
+	hits, err := e.PlanReplace(query, opts.with, results)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "codis:", err)
+		return 1
+	}
+	errs := []error{}
+	if opts.write {
+		written := []string{}
+		written, errs = e.ApplyReplace(hits)
+		for _, path := range written {
+			fmt.Println(path)
+		}
+	} else {
+		patch := ""
+		patch, errs = e.ReplacePatch(hits)
+		fmt.Print(patch)
+	}
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, "codis: skipped", err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+/* 
+** @name: Run
+** @description: Runs a subcommand and returns the exit code.
+*/
+func Run(args []string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage()
+		return 0
+	}
+	subcommand := args[0]
+	if !coutils.ContainsString(Subcommands, subcommand) {
+		fmt.Fprintln(os.Stderr, "codis: unknown subcommand", subcommand)
+		usage()
+		return 2
+	}
+	opts := options{}
+	flags := newFlagSet(subcommand, &opts)
+	query, err := parseArgs(flags, args[1:])
+	if err != nil {
+		return 2
+	}
+	if opts.json {
+		opts.format = "json"
+	} else if opts.ndjson {
+		opts.format = "ndjson"
+	}
+	if !coutils.ContainsString(Formats, opts.format) {
+		fmt.Fprintln(os.Stderr, "codis: unknown format", opts.format)
+		return 2
+	}
+	for _, err := range cosettings.LoadConfig(opts.root) {
+		fmt.Fprintln(os.Stderr, "codis:", err)
+	}
+	if opts.info < 0 || opts.info == 4 || opts.info >= len(coparse.ReturnInfoBoxCategories()) || opts.view < 0 || opts.view > 1 {
+		fmt.Fprintln(os.Stderr, "codis: -info or -view is out of range")
+		return 2
+	}
+	e, err := engine.Open(opts.root, engine.Options{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "codis:", err)
+	}
+	if e == nil {
+		return 1
+	}
+	if opts.context >= 0 {
+		cosearch.SetContext(opts.context)
+	}
+	if opts.depth < 0 {
+		opts.depth = coexplore.MaxDepth
+	}
+	if opts.before >= 0 {
+		cosearch.ContextBefore = opts.before
+	}
+	if opts.after >= 0 {
+		cosearch.ContextAfter = opts.after
+	}
+	categories := splitCategories(opts.categories)
+	filter := engine.Filter{Categories: categories, ExcludeComments: !opts.comments}
+	if subcommand == "serve" {
+		if err := coserve.Serve(e, opts.addr); err != nil {
+			fmt.Fprintln(os.Stderr, "codis:", err)
+			return 1
+		}
+		return 0
+	} else if subcommand == "lsp" {
+		if err := colsp.Serve(e, os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "codis:", err)
+			return 1
+		}
+		return 0
+	}
+	if subcommand == "replace" {
+		return runReplace(e, query, filter, opts)
+	}
+	if opts.format != "text" {
+		return runStructured(e, subcommand, query, filter, opts.format)
+	}
+	results, locations := []string{}, []string{}
+	if subcommand == "search" || subcommand == "fuzzy" {
+		search := e.Search
+		if subcommand == "fuzzy" {
+			search = e.Fuzzy
+		}
+		structuredResults, err := search(context.Background(), query, filter)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "codis: invalid query:", err)
+			return 1
+		}
+		results, locations = cosearch.FormatResults(structuredResults)
+	} else if subcommand == "structural" {
+		structuredResults, err := e.Structural(context.Background(), query, filter)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "codis: invalid pattern:", err)
+			return 1
+		}
+		results, locations = cosearch.FormatResults(structuredResults)
+	} else if subcommand == "similar" {
+		structuredResults, err := e.Similar(context.Background(), query, filter)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "codis:", err)
+			return 1
+		}
+		results, locations = cosearch.FormatResults(structuredResults)
+	} else if subcommand == "tree" {
+		results, locations = coexplore.Show(e.FullTree(), 0, opts.depth, query, opts.dirOnly, opts.info)
+	} else if subcommand == "deps" {
+		results, locations = codependencies.Show(opts.info, codependencies.GetRootFiles(), query)
+	} else if subcommand == "file" {
+		results, locations = cofile.Show(query, opts.view, categories)
+	}
+	printResults(results, locations)
+	return 0
+}
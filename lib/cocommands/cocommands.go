@@ -6,6 +6,69 @@
 
 package cocommands
 
+import (
+  "encoding/json"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+
+  cotypes "codis/lib/cotypes"
+  cosearch "codis/lib/cosearch"
+  cosettings "codis/lib/cosettings"
+  cohistory "codis/lib/cohistory"
+  cobookmarks "codis/lib/cobookmarks"
+  coreplace "codis/lib/coreplace"
+  codependencies "codis/lib/codependencies"
+)
+
+// structs
+
+type Arg struct {
+  Name     string
+  Kind     string // int, string, path or choice
+  Choices  []string
+  Values   func() []string // completions of a string argument
+  Optional bool
+  Rest     bool // takes the rest of the line (can contain spaces)
+}
+
+type Command struct {
+  Name        string
+  Aliases     []string
+  Args        []Arg
+  Description string
+  Run         func(state State, args []string) Outcome
+}
+
+type State struct {
+  Results    []cotypes.Result
+  LastSearch *cohistory.Entry
+  Mark       *cobookmarks.Bookmark // the location that ctrl+b selected
+  Pattern    string // the regex of the shown quick search results (empty for other searches)
+  Partial    bool // the search was stopped before it returned all results
+  Plan       func(pattern string, replacement string, results []cotypes.Result) ([]coreplace.Hit, error) // plans a replacement on the index (under its lock)
+}
+
+type Outcome struct {
+  Result    []string
+  Locations []string
+  Directory string
+  Reindex   bool
+  Goto      int
+  Open      string
+  Line      int
+  Run       *cohistory.Entry // a saved search to run
+  Picker    bool // shows the picker with the saved searches
+  Marks     bool // shows the bookmarks
+  Hits      []coreplace.Hit // replacements to review
+}
+
+// globals
+
+var Registry []Command
+var ExportFormats = []string{"json", "ndjson", "grep", "vimgrep", "emacs"}
+
 func info() string {
   infoString := `
 
@@ -26,6 +89,10 @@ func info() string {
   return infoString
 }
 
+/* 
+** @name: help
+** @description: Returns the help pages, the commands on the first page are generated from the registry.
+*/
 func help() []string {
   helpString := []string{
     `
@@ -33,16 +100,33 @@ func help() []string {
       <tab> to switch between query types
       / to enter query mode
       : to enter command mode
-      <ctrl+f> to change settings
+      <ctrl+f> to change settings (e.g. search as you type)
       <enter> to submit query
-    `,
+      <up> or <down> to browse the previous queries (that start with the typed text)
+      <esc> to clear results (and cancel a running search)
+      <ctrl+o> to open the selected result in the file viewer
+      <ctrl+e> to open the selected result in $VISUAL/$EDITOR
+      <ctrl+b> to bookmark the selected result (type a note and press <enter>)
+      <ctrl+s> to find code that is similar to the selected result
+    COMMAND MODE (<tab> completes commands and arguments):
+` + commandList() + `    `,
     `
     QUICK SEARCH:
       DESCRIPTION:
-        Simply find the submitted text in the parent directories.
+        Simply find the submitted text in the parent directories. Results are
+        listed on the left, the selected result is previewed on the right.
+        Add kind:<label> to only find lines with a label (e.g. kind:function
+        or a custom label from set labels.<name> <regex>).
       COMMANDS:
         <enter> to search.
         <ctrl+k> or <ctrl+j> to iterate between results.
+        <ctrl+t> to group the results by file (also for fuzzy/symbol search).
+        <ctrl+n> or <ctrl+p> to jump to the next/previous file when grouped.
+        <ctrl+y> to collapse or expand the selected file when grouped.
+        <ctrl+r> to refine: search within the current results (also fuzzy).
+        <ctrl+x> to go back to the results before the last refinement.
+        replace <text> (command mode) to replace the matches: y/n accepts or
+        rejects each change, w writes the files and p writes codis.patch.
     `,
     `
     FUZZY SEARCH:
@@ -58,6 +142,7 @@ func help() []string {
         Shows the filetree with info per file.
       COMMANDS:
         <enter>+integer to zoom in.
+        integer+<ctrl+e> to open a file in $VISUAL/$EDITOR.
         <ctrl+d> to view directories only.
         <ctrl+g> to change displayed info.
         <ctrl+k> or <ctrl+j> to iterate between results.
@@ -77,17 +162,565 @@ func help() []string {
       COMMANDS:
         <ctrl+k> or <ctrl+j> to iterate between results.
         <ctrl+g> to change type of view.
+        <ctrl+o> to open the file in the file viewer.
+    `,
+    `
+    FILE VIEWER:
+      DESCRIPTION: 
+        Shows a full file with syntax highlighting, opened at the matched line.
+      COMMANDS:
+        <up>/<down> or k/j to scroll, <pgup>/<pgdown> to scroll a page.
+        g or G to go to the first or last line.
+        / to search in the file, n or N for the next/previous match.
+        : followed by a number to jump to a line.
+        e to edit the file at the current line.
+        b to bookmark the current line.
+        s to find code that is similar to the current line's function/block.
+        <esc> or q to close the viewer.
+    `,
+    `
+    SYMBOL SEARCH:
+      DESCRIPTION: 
+        Finds the declarations of functions and objects by (part of) their name.
+      COMMANDS:
+        <enter> to search.
+        <ctrl+k> or <ctrl+j> to iterate between results.
+    `,
+    `
+    STRUCTURAL SEARCH:
+      DESCRIPTION: 
+        Finds Go code by its syntax instead of its text. The query is Go code
+        in which $name matches any expression, statement or name (the same
+        name matches the same code) and ... matches any number of arguments,
+        statements or fields. Add has <pattern> or !has <pattern> to only keep
+        matches that do (not) contain other code. For example:
+          coexplore.Show($a, $b, $c, $d, ...)
+          if $err != nil { ... } !has return ...
+      COMMANDS:
+        <enter> to search.
+        <ctrl+k> or <ctrl+j> to iterate between results.
+    `,
+    `
+    SIMILAR CODE:
+      DESCRIPTION: 
+        Finds the functions/blocks that are the most similar to a pasted
+        snippet, also when their names differ (e.g. copy-pasted logic). Type
+        @path:line (or press <ctrl+s> on a result) to use the code around
+        that line. The most similar code is listed first.
+      COMMANDS:
+        <enter> to search.
+        <ctrl+k> or <ctrl+j> to iterate between results.
     `,
   }
   return helpString
 }
 
-func ParseCommand(command string) ([]string, []string) {
-  if command == "info" {
-    return []string{info()}, []string{"info page"}
-  } else if command == "help" {
-    return help(), []string{"help page","help page","help page","help page","help page","help page",}
+
+/* 
+** @name: commandList
+** @description: Returns the usage and description of every command in the registry (for the help page).
+*/
+func commandList() string {
+  list := ""
+  for _, command := range Registry {
+    list += "      " + Usage(command) + "\n"
+    if len(command.Aliases) > 0 {
+      list += "        " + command.Description + " (alias: " + strings.Join(command.Aliases, ", ") + ")\n"
+    } else {
+      list += "        " + command.Description + "\n"
+    }
+  }
+  return list
+}
+
+/* 
+** @name: Usage
+** @description: Returns how a command should be called, optional arguments are between brackets.
+*/
+func Usage(command Command) string {
+  usage := command.Name
+  for _, arg := range command.Args {
+    name := arg.Name
+    if arg.Kind == "choice" {
+      name = strings.Join(arg.Choices, "|")
+    }
+    if arg.Optional {
+      usage += " [" + name + "]"
+    } else {
+      usage += " <" + name + ">"
+    }
+  }
+  return usage
+}
+
+/* 
+** @name: Lookup
+** @description: Returns the command with a name or alias.
+*/
+func Lookup(name string) (Command, bool) {
+  for _, command := range Registry {
+    if command.Name == name || containsString(command.Aliases, name) {
+      return command, true
+    }
+  }
+  return Command{}, false
+}
+
+func containsString(values []string, value string) bool {
+  for _, current := range values {
+    if current == value {
+      return true
+    }
+  }
+  return false
+}
+
+/* 
+** @name: message
+** @description: Returns an outcome that only shows a message.
+*/
+func message(text string, location string) Outcome {
+  return Outcome{Result: []string{text}, Locations: []string{location}}
+}
+
+/* 
+** @name: usageError
+** @description: Returns an outcome that explains how a command should be called.
+*/
+func usageError(command Command, problem string) Outcome {
+  return message(problem + "\n\nusage: " + Usage(command) + "\n  " + command.Description, "invalid command")
+}
+
+/* 
+** @name: checkArgs
+** @description: Returns an error message if the arguments don't match the types of a command (empty if they do).
+*/
+func checkArgs(command Command, args []string) string {
+  required := 0
+  for _, arg := range command.Args {
+    if !arg.Optional {
+      required += 1
+    }
+  }
+  if len(args) < required {
+    return "missing arguments"
+  } else if len(args) > len(command.Args) && (len(command.Args) == 0 || !command.Args[len(command.Args)-1].Rest) {
+    return "too many arguments"
+  }
+  for index, value := range args {
+    arg := command.Args[min(index, len(command.Args)-1)] // the last argument can take several words
+    if lines, err := strconv.Atoi(value); arg.Kind == "int" && (err != nil || lines < 0) {
+      return arg.Name + " should be a number >= 0, not " + value
+    } else if arg.Kind == "choice" && !containsString(arg.Choices, value) {
+      return value + " should be one of " + strings.Join(arg.Choices, ", ")
+    }
+  }
+  return ""
+}
+
+/* 
+** @name: restOf
+** @description: Returns the input without its first words (the rest keeps its spacing).
+*/
+func restOf(input string, skip int) string {
+  for ; skip > 0; skip-- {
+    input = strings.TrimLeft(input, " \t")
+    input = input[strings.IndexAny(input + " ", " \t"):]
+  }
+  return strings.TrimLeft(input, " \t")
+}
+
+/* 
+** @name: Run
+** @description: Parses and runs a command from command mode.
+*/
+func Run(input string, state State) Outcome {
+  fields := strings.Fields(input)
+  if len(fields) == 0 {
+    return message("type a command, or help to list them", "invalid command")
+  }
+  command, ok := Lookup(fields[0])
+  if !ok {
+    return message("unknown command: " + fields[0] + " (type help to list the commands)", "invalid command")
+  }
+  if problem := checkArgs(command, fields[1:]); problem != "" {
+    return usageError(command, problem)
+  }
+  args := fields[1:]
+  if len(args) >= len(command.Args) && len(command.Args) > 0 && command.Args[len(command.Args)-1].Rest { // the last argument takes the rest of the line (spaces included)
+    args = append(args[:len(command.Args)-1], restOf(input, len(command.Args)))
+  }
+  return command.Run(state, args)
+}
+
+/* 
+** @name: commonPrefix
+** @description: Returns the longest prefix that all values share.
+*/
+func commonPrefix(values []string) string {
+  if len(values) == 0 {
+    return ""
+  }
+  prefix := values[0]
+  for _, value := range values[1:] {
+    for !strings.HasPrefix(value, prefix) {
+      prefix = prefix[:len(prefix)-1]
+    }
+  }
+  return prefix
+}
+
+/* 
+** @name: completePath
+** @description: Returns the files and directories that start with a (partial) path, directories end with a slash.
+*/
+func completePath(partial string) []string {
+  candidates := []string{}
+  matches, _ := filepath.Glob(partial + "*")
+  for _, match := range matches {
+    if info, err := os.Stat(match); err == nil && info.IsDir() {
+      match += string(filepath.Separator)
+    }
+    candidates = append(candidates, match)
+  }
+  return candidates
+}
+
+/* 
+** @name: Complete
+** @description: Completes the command or argument that is being typed, returns the new input and the candidates.
+*/
+func Complete(input string) (string, []string) {
+  fields := strings.Fields(input)
+  if len(fields) == 0 || (len(fields) == 1 && !strings.HasSuffix(input, " ")) {
+    candidates := []string{}
+    for _, command := range Registry {
+      for _, name := range append([]string{command.Name}, command.Aliases...) {
+        if len(fields) == 0 || strings.HasPrefix(name, fields[0]) {
+          candidates = append(candidates, name)
+        }
+      }
+    }
+    if len(candidates) == 1 {
+      return candidates[0] + " ", candidates
+    } else if len(candidates) > 1 {
+      return commonPrefix(candidates), candidates
+    }
+    return input, candidates
+  }
+  command, ok := Lookup(fields[0])
+  if strings.HasSuffix(input, " ") {
+    fields = append(fields, "")
+  }
+  position := len(fields) - 2
+  if !ok || position >= len(command.Args) {
+    return input, []string{}
+  }
+  partial, candidates := fields[len(fields)-1], []string{}
+  if arg := command.Args[position]; arg.Kind == "choice" || arg.Values != nil {
+    choices := arg.Choices
+    if arg.Values != nil {
+      choices = arg.Values()
+    }
+    for _, choice := range choices {
+      if strings.HasPrefix(choice, partial) {
+        candidates = append(candidates, choice)
+      }
+    }
+  } else if arg.Kind == "path" {
+    candidates = completePath(partial)
+  }
+  if len(candidates) == 0 {
+    return input, candidates
+  }
+  completed := commonPrefix(candidates)
+  if len(candidates) == 1 && !strings.HasSuffix(completed, string(filepath.Separator)) {
+    completed += " "
+  }
+  return strings.Join(fields[:len(fields)-1], " ") + " " + completed, candidates
+}
+
+// commands
+
+/* 
+** @name: runHelp
+** @description: Shows the help pages, or the usage of one command.
+*/
+func runHelp(state State, args []string) Outcome {
+  if len(args) == 1 {
+    command, ok := Lookup(args[0])
+    if !ok {
+      return message("unknown command: " + args[0], "invalid command")
+    }
+    page := "\n\n    " + strings.ToUpper(command.Name) + ":\n      USAGE:\n        " + Usage(command) + "\n      DESCRIPTION:\n        " + command.Description + "\n"
+    if len(command.Aliases) > 0 {
+      page += "      ALIASES:\n        " + strings.Join(command.Aliases, ", ") + "\n"
+    }
+    return message(page, "help page")
+  }
+  outcome := Outcome{Result: help(), Locations: []string{}}
+  for range outcome.Result {
+    outcome.Locations = append(outcome.Locations, "help page")
+  }
+  return outcome
+}
+
+/* 
+** @name: runSet
+** @description: Lists the settings (set), shows one (set key) or changes one (set key value).
+*/
+func runSet(state State, args []string) Outcome {
+  if len(args) == 0 {
+    return message("\n\n    SETTINGS (set <key> <value> to change one):\n\n" + cosettings.List(), "settings")
+  }
+  if len(args) == 2 {
+    if args[1] == `""` { // an empty value
+      args[1] = ""
+    }
+    if err := cosettings.Set(args[0], args[1]); err != nil {
+      return message(err.Error(), "invalid command")
+    }
+  }
+  value, err := cosettings.Get(args[0])
+  if err != nil && len(args) == 1 {
+    return message(err.Error(), "invalid command")
+  }
+  outcome := message(args[0] + " = " + value, "settings")
+  if len(args) == 2 && (strings.HasPrefix(args[0], "categories.") || strings.HasPrefix(args[0], "labels.")) {
+    outcome.Result[0] += " (reindexed)"
+    outcome.Reindex = true
+  }
+  return outcome
+}
+
+/* 
+** @name: runCd
+** @description: Switches to another directory and indexes it.
+*/
+func runCd(state State, args []string) Outcome {
+  directory, err := filepath.Abs(args[0])
+  if err != nil {
+    return message(err.Error(), "invalid command")
+  }
+  if info, err := os.Stat(directory); err != nil || !info.IsDir() {
+    return message("not a directory: " + directory, "invalid command")
+  }
+  return Outcome{Result: []string{"indexed " + directory}, Locations: []string{"cd"}, Directory: directory}
+}
+
+/* 
+** @name: runReindex
+** @description: Parses the current directory again.
+*/
+func runReindex(state State, args []string) Outcome {
+  return Outcome{Result: []string{"reindexed"}, Locations: []string{"reindex"}, Reindex: true}
+}
+
+/* 
+** @name: runExport
+** @description: Writes the results of the last search to a file (as JSON or in a grep/quickfix format).
+*/
+func runExport(state State, args []string) Outcome {
+  if len(state.Results) == 0 {
+    return message("nothing to export, run a quick, fuzzy or symbol search first", "invalid command")
+  }
+  format := strings.TrimPrefix(filepath.Ext(args[0]), ".")
+  if len(args) == 2 {
+    format = args[1]
+  } else if !containsString(ExportFormats, format) {
+    format = "vimgrep"
+  }
+  content := []byte{}
+  if format == "json" {
+    encoded, err := json.MarshalIndent(state.Results, "", "  ")
+    if err != nil {
+      return message(err.Error(), "invalid command")
+    }
+    content = append(encoded, '\n')
   } else {
-    return []string{"invalid command"}, []string{"invalid command"}
+    for _, result := range state.Results {
+      line := cosearch.FormatLine(result, format)
+      if format == "ndjson" {
+        encoded, _ := json.Marshal(result)
+        line = string(encoded)
+      }
+      content = append(content, line + "\n"...)
+    }
+  }
+  if err := os.WriteFile(args[0], content, 0644); err != nil {
+    return message(err.Error(), "invalid command")
+  }
+  return message("exported " + strconv.Itoa(len(state.Results)) + " results to " + args[0] + " (" + format + ")", "export")
+}
+
+/* 
+** @name: runGoto
+** @description: Jumps to a result (by number) or opens a file in the viewer (file or file:line).
+*/
+func runGoto(state State, args []string) Outcome {
+  if number, err := strconv.Atoi(args[0]); err == nil {
+    return Outcome{Result: []string{""}, Locations: []string{"goto"}, Goto: number}
+  }
+  path, line := args[0], 1
+  if separator := strings.LastIndex(path, ":"); separator > 0 {
+    if number, err := strconv.Atoi(path[separator+1:]); err == nil {
+      path, line = path[:separator], number
+    }
+  }
+  path, _ = filepath.Abs(path)
+  if info, err := os.Stat(path); err != nil || info.IsDir() {
+    return message("not a file: " + path, "invalid command")
+  }
+  return Outcome{Result: []string{""}, Locations: []string{"goto"}, Open: path, Line: line}
+}
+
+/* 
+** @name: runSave
+** @description: Saves the last search (query, mode and filters) under a name.
+*/
+func runSave(state State, args []string) Outcome {
+  if state.LastSearch == nil {
+    return message("there is no search to save yet", "invalid command")
+  }
+  if err := cohistory.Save(args[0], *state.LastSearch); err != nil {
+    return message(err.Error(), "invalid command")
+  }
+  return message("saved " + args[0] + ": " + cohistory.Describe(*state.LastSearch), "saved searches")
+}
+
+/* 
+** @name: runRun
+** @description: Runs a saved search, or shows the picker with the saved searches.
+*/
+func runRun(state State, args []string) Outcome {
+  if len(cohistory.Saved) == 0 {
+    return message("there are no saved searches (save the last search with save <name>)", "saved searches")
+  } else if len(args) == 0 {
+    return Outcome{Result: []string{""}, Locations: []string{"saved searches"}, Picker: true}
+  }
+  entry, ok := cohistory.Saved[args[0]]
+  if !ok {
+    return message("no saved search named " + strconv.Quote(args[0]), "invalid command")
+  }
+  return Outcome{Result: []string{""}, Locations: []string{"saved searches"}, Run: &entry}
+}
+
+/* 
+** @name: runMark
+** @description: Bookmarks the location that was selected with ctrl+b (with an optional note).
+*/
+func runMark(state State, args []string) Outcome {
+  if state.Mark == nil {
+    return message("nothing to bookmark, select a result and press <ctrl+b>", "invalid command")
+  }
+  bookmark := *state.Mark
+  if len(args) == 1 {
+    bookmark.Note = args[0]
+  }
+  if err := cobookmarks.Add(bookmark); err != nil {
+    return message(err.Error(), "invalid command")
+  }
+  return message("bookmarked " + bookmark.Path + ":" + strconv.Itoa(bookmark.Line) + " (type marks to list the bookmarks)", "bookmarks")
+}
+
+/* 
+** @name: runNote
+** @description: Changes (or with no text removes) the note of a bookmark.
+*/
+func runNote(state State, args []string) Outcome {
+  number, _ := strconv.Atoi(args[0])
+  note := ""
+  if len(args) == 2 {
+    note = args[1]
+  }
+  if err := cobookmarks.SetNote(number, note); err != nil {
+    return message(err.Error(), "invalid command")
+  }
+  return Outcome{Result: []string{""}, Locations: []string{"bookmarks"}, Marks: true}
+}
+
+/* 
+** @name: runExportMarks
+** @description: Writes the bookmarks to a file as a markdown list or in vim's quickfix format.
+*/
+func runExportMarks(state State, args []string) Outcome {
+  if len(cobookmarks.Bookmarks) == 0 {
+    return message("there are no bookmarks to export", "invalid command")
+  }
+  format := "quickfix"
+  if len(args) == 2 {
+    format = args[1]
+  } else if extension := filepath.Ext(args[0]); extension == ".md" || extension == ".markdown" {
+    format = "markdown"
+  }
+  if err := os.WriteFile(args[0], []byte(cobookmarks.Export(format)), 0644); err != nil {
+    return message(err.Error(), "invalid command")
+  }
+  return message("exported " + strconv.Itoa(len(cobookmarks.Bookmarks)) + " bookmarks to " + args[0] + " (" + format + ")", "export")
+}
+
+/* 
+** @name: runReplace
+** @description: Replaces the matches of the shown quick search results (after reviewing every changed line).
+*/
+func runReplace(state State, args []string) Outcome {
+  if state.Pattern == "" || len(state.Results) == 0 {
+    return message("replace works on the results of a quick search (search first, then type replace <replacement>)", "invalid command")
+  } else if state.Partial {
+    return message("the search was stopped before it found every match, search again and let it finish before replacing", "invalid command")
+  }
+  replacement := ""
+  if len(args) == 1 {
+    replacement = args[0]
+  }
+  hits, err := state.Plan(state.Pattern, replacement, state.Results)
+  if err != nil {
+    return message(err.Error(), "invalid command")
+  } else if len(hits) == 0 {
+    return message("replacing " + strconv.Quote(state.Pattern) + " with " + strconv.Quote(replacement) + " changes nothing", "replace")
+  }
+  return Outcome{Result: []string{""}, Locations: []string{"replace"}, Hits: hits}
+}
+
+func init() {
+  Registry = []Command{
+    {"help", []string{"h", "?"}, []Arg{{Name: "command", Kind: "string", Optional: true}}, "shows the help pages (or how to use a command)", runHelp},
+    {"info", []string{"about"}, []Arg{}, "shows information about codis", func(state State, args []string) Outcome {
+      return message(info(), "info page")
+    }},
+    {"orphans", []string{"unused"}, []Arg{}, "lists unimported code files and unreferenced symbols", func(state State, args []string) Outcome {
+      results, locations := codependencies.ShowOrphans()
+      return Outcome{Result: results, Locations: locations}
+    }},
+    {"set", []string{}, []Arg{{Name: "key", Kind: "string", Values: cosettings.Keys, Optional: true}, {Name: "value", Kind: "string", Optional: true, Rest: true}}, "lists the settings, or shows/changes one (e.g. set context 5)", runSet},
+    {"cd", []string{"root"}, []Arg{{Name: "directory", Kind: "path"}}, "switches to (and indexes) another directory", runCd},
+    {"reindex", []string{"r"}, []Arg{}, "parses the current directory again", runReindex},
+    {"export", []string{"w"}, []Arg{{Name: "file", Kind: "path"}, {Name: "format", Kind: "choice", Choices: ExportFormats, Optional: true}}, "writes the results of the last search to a file (json, ndjson or a grep/quickfix format)", runExport},
+    {"goto", []string{"g"}, []Arg{{Name: "result|file:line", Kind: "path"}}, "jumps to a result by number, or opens a file in the viewer", runGoto},
+    {"save", []string{}, []Arg{{Name: "name", Kind: "string", Values: cohistory.Names}}, "saves the last search with its mode and filters", runSave},
+    {"run", []string{"searches"}, []Arg{{Name: "name", Kind: "string", Values: cohistory.Names, Optional: true}}, "runs a saved search (without a name: pick one from a list)", runRun},
+    {"mark", []string{"m"}, []Arg{{Name: "note", Kind: "string", Optional: true, Rest: true}}, "bookmarks the location selected with <ctrl+b> (with an optional note)", runMark},
+    {"marks", []string{"bookmarks"}, []Arg{}, "lists the bookmarks (enter opens one, d removes one)", func(state State, args []string) Outcome {
+      if len(cobookmarks.Bookmarks) == 0 {
+        return message("there are no bookmarks (select a result and press <ctrl+b>)", "bookmarks")
+      }
+      return Outcome{Result: []string{""}, Locations: []string{"bookmarks"}, Marks: true}
+    }},
+    {"note", []string{}, []Arg{{Name: "bookmark", Kind: "int"}, {Name: "note", Kind: "string", Optional: true, Rest: true}}, "changes the note of a bookmark", runNote},
+    {"unmark", []string{}, []Arg{{Name: "bookmark", Kind: "int"}}, "removes a bookmark", func(state State, args []string) Outcome {
+      number, _ := strconv.Atoi(args[0])
+      if err := cobookmarks.Remove(number); err != nil {
+        return message(err.Error(), "invalid command")
+      }
+      return message("removed bookmark " + args[0], "bookmarks")
+    }},
+    {"exportmarks", []string{"wm"}, []Arg{{Name: "file", Kind: "path"}, {Name: "format", Kind: "choice", Choices: cobookmarks.ExportFormats, Optional: true}}, "writes the bookmarks to a file (markdown or quickfix)", runExportMarks},
+    {"replace", []string{"s"}, []Arg{{Name: "replacement", Kind: "string", Optional: true, Rest: true}}, "replaces the matches of the quick search results ($1 refers to a group), shows every change to accept or reject first", runReplace},
+    {"unsave", []string{}, []Arg{{Name: "name", Kind: "string", Values: cohistory.Names}}, "removes a saved search", func(state State, args []string) Outcome {
+      if err := cohistory.Delete(args[0]); err != nil {
+        return message(err.Error(), "invalid command")
+      }
+      return message("removed " + args[0], "saved searches")
+    }},
   }
 }
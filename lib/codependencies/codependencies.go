@@ -7,10 +7,14 @@
 package codependencies
 
 import (
+  "path/filepath"
+  "sort"
   "strings"
   "strconv"
+  "unicode"
 
   coparse "codis/lib/coparse"
+  cotypes "codis/lib/cotypes"
   coutils "codis/lib/coutils"
 )
 
@@ -19,6 +23,8 @@ import (
 var dependencyTree string
 var antiCircularDependencies []string
 var id = -1
+var EntryGlobs = []string{"main.*", "__main__.py", "setup.py", "index.*"}
+var testGlobs = []string{"*_test.*", "test_*.py", "*.test.*", "*.spec.*"}
 
 func selectInfoBox(filepath string, line string, infoIndex int) string {
 	if infoIndex == 0 {
@@ -31,6 +37,8 @@ func selectInfoBox(filepath string, line string, infoIndex int) string {
 		return coutils.FormatInfoBox(line, strconv.Itoa(coparse.TypeCountsDomain[coparse.CurrentDirectory + filepath]))
 	} else if infoIndex == 4 {
 		return coutils.FormatInfoBox(line, strconv.Itoa(coparse.QueryCounts[coparse.CurrentDirectory + filepath]))
+	} else if count, ok := coparse.InfoBoxCount(infoIndex, coparse.CurrentDirectory + filepath); ok {
+		return coutils.FormatInfoBox(line, strconv.Itoa(count))
 	} else {
 		return "None"
 	}
@@ -80,7 +88,7 @@ func splitDependencyTree(dependencyTree string, infoIndex int) ([]string, []stri
 	tempPage := coutils.FormatInfoBox("", "showing: " + coparse.InfoBoxCategories[infoIndex])
 	for index, line := range strings.Split(dependencyTree, "\n") {
 		tempPage += line + "\n"
-		if index % 15 == 0 && index != 0 {
+		if index % coparse.PageSize == 0 && index != 0 {
 			pages = append(pages, tempPage)
 			locations = append(locations, "dependency explorer")
 			tempPage = coutils.FormatInfoBox("", "showing: " + coparse.InfoBoxCategories[infoIndex] + "\n")
@@ -120,3 +128,119 @@ func Show(infoIndex int, rootFiles []string, query string) ([]string, []string)
 	}
 	return splitDependencyTree(dependencyTree, infoIndex)
 }
+
+/* 
+** @name: getEntryFiles
+** @description: Returns the files that are recognized entry points (main package, script, test or entry glob). 
+*/
+func getEntryFiles() map[string]bool {
+	entryFiles := make(map[string]bool)
+	for _, key := range coparse.OrderedKeys {
+		line := strings.TrimSpace(coparse.LabeledRows[key])
+		if line == "package main" || strings.HasPrefix(line, "if __name__ ==") {
+			entryFiles[key.FilePath] = true
+		} else if key.Linenumber == 1 {
+			for _, glob := range append(EntryGlobs, testGlobs...) {
+				if matched, _ := filepath.Match(glob, key.Filename); matched {
+					entryFiles[key.FilePath] = true
+				}
+			}
+		}
+	}
+	return entryFiles
+}
+
+/* 
+** @name: GetOrphanFiles
+** @description: Returns the code files that are neither imported nor an entry point. 
+*/
+func GetOrphanFiles() []string {
+	orphanFiles := []string{}
+	entryFiles := getEntryFiles()
+	importedFiles := []string{}
+	for _, files := range coparse.Imports {
+		importedFiles = append(importedFiles, files...)
+	}
+	for path, category := range coparse.Categories {
+		relativePath := path[len(coparse.CurrentDirectory):]
+		if category == "code" && !entryFiles[path] && !coutils.ContainsString(importedFiles, relativePath) {
+			orphanFiles = append(orphanFiles, relativePath)
+		}
+	}
+	sort.Strings(orphanFiles)
+	return orphanFiles
+}
+
+/* 
+** @name: isExported
+** @description: Returns true if a symbol can be referenced from outside of its file. 
+*/
+func isExported(symbol cotypes.Symbol) bool {
+	if symbol.Name == "main" || symbol.Name == "init" || strings.HasPrefix(symbol.Name, "_") {
+		return false
+	}
+	if strings.HasSuffix(symbol.Filename, ".go") {
+		return unicode.IsUpper([]rune(symbol.Name)[0])
+	}
+	return true
+}
+
+/* 
+** @name: GetUnusedSymbols
+** @description: Returns the exported symbols that are never referenced anywhere in the index. 
+*/
+func GetUnusedSymbols() []cotypes.Symbol {
+	separators := " \t:;{}()[],.*&!<>=+-/\"'`"
+	references := make(map[string]int)
+	lines := make(map[string]string)
+	for _, key := range coparse.OrderedKeys {
+		lines[key.FilePath + ":" + strconv.Itoa(key.Linenumber)] = coparse.LabeledRows[key]
+		for _, token := range coutils.SplitAny(coparse.LabeledRows[key], separators) {
+			references[token] += 1
+		}
+	}
+	unusedSymbols := []cotypes.Symbol{}
+	for _, symbol := range coparse.Symbols {
+		if !isExported(symbol) {
+			continue
+		}
+		declarations := 0
+		for _, token := range coutils.SplitAny(lines[symbol.FilePath + ":" + strconv.Itoa(symbol.Linenumber)], separators) {
+			if token == symbol.Name { declarations += 1 }
+		}
+		if references[symbol.Name] <= declarations {
+			unusedSymbols = append(unusedSymbols, symbol)
+		}
+	}
+	return unusedSymbols
+}
+
+/* 
+** @name: ShowOrphans
+** @description: Returns the orphan report (unused files and unreferenced symbols) as pages. 
+*/
+func ShowOrphans() ([]string, []string) {
+	report := "orphan files:\n---\n"
+	for _, orphanFile := range GetOrphanFiles() {
+		report += "\t" + orphanFile + "\n"
+	}
+	report += "\nunreferenced symbols:\n---\n"
+	for _, symbol := range GetUnusedSymbols() {
+		line := "\t" + symbol.Name + " (" + symbol.Kind + ")"
+		report += line + coutils.FormatInfoBox(line, symbol.Filename + ", line " + strconv.Itoa(symbol.Linenumber))
+	}
+	pages := []string{}
+	locations := []string{}
+	tempPage := ""
+	for index, line := range strings.Split(report, "\n") {
+		tempPage += line + "\n"
+		if index % coparse.PageSize == 0 && index != 0 {
+			pages = append(pages, tempPage)
+			locations = append(locations, "orphan report")
+			tempPage = ""
+		}
+	}
+	pages = append(pages, tempPage)
+	locations = append(locations, "orphan report")
+	return pages, locations
+}
@@ -0,0 +1,71 @@
+/* 
+** @name: coeditor
+** @author: Timo Kats
+** @description: Builds the command that opens a file at a line in the editor of the user ($VISUAL or $EDITOR).
+*/
+
+package coeditor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// globals
+
+var Template = os.Getenv("CODIS_EDITOR_TEMPLATE")
+var templates = map[string]string{
+	"code": "--goto {file}:{line}",
+	"codium": "--goto {file}:{line}",
+	"subl": "{file}:{line}",
+	"zed": "{file}:{line}",
+	"hx": "{file}:{line}",
+	"helix": "{file}:{line}",
+}
+
+/* 
+** @name: Editor
+** @description: Returns the editor of the user ($VISUAL before $EDITOR, vi if neither is set).
+*/
+func Editor() string {
+	if editor := strings.TrimSpace(os.Getenv("VISUAL")); editor != "" {
+		return editor
+	} else if editor := strings.TrimSpace(os.Getenv("EDITOR")); editor != "" {
+		return editor
+	}
+	return "vi"
+}
+
+/* 
+** @name: editorTemplate
+** @description: Returns the argument template of an editor ("+{line} {file}" works for vim, nano, emacs, etc).
+*/
+func editorTemplate(editor string) string {
+	if Template != "" {
+		return Template
+	} else if template, ok := templates[filepath.Base(editor)]; ok {
+		return template
+	}
+	return "+{line} {file}"
+}
+
+/* 
+** @name: Command
+** @description: Returns the command that opens a file at a (1-based) line in the editor of the user.
+*/
+func Command(filePath string, linenumber int) (*exec.Cmd, error) {
+	editor := strings.Fields(Editor())
+	if len(editor) == 0 {
+		return nil, errors.New("no editor set, use $VISUAL or $EDITOR")
+	}
+	args := editor[1:]
+	for _, field := range strings.Fields(editorTemplate(editor[0])) {
+		field = strings.ReplaceAll(field, "{line}", strconv.Itoa(linenumber))
+		args = append(args, strings.ReplaceAll(field, "{file}", filePath))
+	}
+	return exec.Command(editor[0], args...), nil
+}
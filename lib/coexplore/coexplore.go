@@ -7,6 +7,7 @@
 package coexplore
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"time"
@@ -24,6 +25,7 @@ var minLevel = 0
 var selectedId = -1
 var fileTree = "" 
 var selectedPath = ""
+var MaxDepth = 5
 
 // structs
 
@@ -60,8 +62,8 @@ func NewTree(root string) (result *Node, err error) {
 	}
 	parents := make(map[string]*Node)
 	walkFunc := func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+		if err != nil { // unreadable paths are skipped (the parser reports them)
+			return nil
 		}
 		parents[path] = &Node{
 			FullPath: path,
@@ -70,9 +72,7 @@ func NewTree(root string) (result *Node, err error) {
 		}
 		return nil
 	}
-	if err = filepath.Walk(absRoot, walkFunc); err != nil {
-		return
-	}
+	filepath.Walk(absRoot, walkFunc)
 	for path, node := range parents {
 		parentPath := filepath.Dir(path)
 		parent, exists := parents[parentPath]
@@ -87,6 +87,9 @@ func NewTree(root string) (result *Node, err error) {
 			}
 		}
 	}
+	if result == nil {
+		err = errors.New("cannot read " + absRoot)
+	}
 	return
 }
 
@@ -108,6 +111,8 @@ func selectInfoBox(node *Node, line string, infoIndex int, escape bool) string {
 		return coutils.FormatInfoBox(line, strconv.Itoa(coparse.TypeCountsDomain[node.FullPath]))
 	} else if infoIndex == 4 {
 		return coutils.FormatInfoBox(line, strconv.Itoa(coparse.QueryCounts[node.FullPath]))
+	} else if count, ok := coparse.InfoBoxCount(infoIndex, node.FullPath); ok {
+		return coutils.FormatInfoBox(line, strconv.Itoa(count))
 	} else {
 		return "fuck you"
 	}
@@ -168,7 +173,7 @@ func splitFileTree(fileTree string, infoIndex int) ([]string, []string) {
 	tempPage := coutils.FormatInfoBox("", "showing: " + coparse.InfoBoxCategories[infoIndex])
 	for index, line := range strings.Split(fileTree, "\n") {
 		tempPage += line + "\n"
-		if index % 15 == 0 && index != 0 {
+		if index % coparse.PageSize == 0 && index != 0 {
 			pages = append(pages, tempPage)
 			locations = append(locations, "file explorer")
 			tempPage = coutils.FormatInfoBox("", "showing: " + coparse.InfoBoxCategories[infoIndex] + "\n")
@@ -181,6 +186,17 @@ func splitFileTree(fileTree string, infoIndex int) ([]string, []string) {
 
 // caller function
 
+/* 
+** @name: SelectPath
+** @description: Returns the full path of the file/directory with an id in the file tree (empty if there is none).
+*/
+func SelectPath(fullTree *Node, selectedLine int) string {
+	selectedPath = ""
+	selectedId = -1
+	selectDirectory(selectedLine, []string{}, fullTree)
+	return selectedPath
+}
+
 /* 
 ** @name: Show
 ** @description: Caller function that prints the filetree based on some parameters.  
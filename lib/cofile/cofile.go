@@ -7,18 +7,40 @@
 package cofile
 
 import (
+  "path/filepath"
   "strings"
 
   coutils "codis/lib/coutils"
   coparse "codis/lib/coparse"
+  cotypes "codis/lib/cotypes"
 )
 
+/* 
+** @name: Search
+** @description: Streams a structured result for every file that matches the query. Stops when emit returns false.
+*/
+func Search(query string, contextCategories []string, emit func(cotypes.Result) bool) {
+  for index, key := range coparse.OrderedKeys {
+    if key.Linenumber != 1 || !strings.Contains(key.Filename, query) {
+      continue
+    }
+    if len(contextCategories) == 0 || coutils.ContainsString(contextCategories, key.Category) {
+      path, err := filepath.Rel(coparse.CurrentDirectory, key.FilePath)
+      if err != nil {
+        path = key.FilePath
+      }
+      result := cotypes.Result{Path: path, Line: 1, ColumnStart: 1, ColumnEnd: 1, Matches: [][2]int{}, Labels: []string{key.Category}, Text: key.Filename, ContextBefore: []string{}, ContextAfter: []string{}, Index: index}
+      if !emit(result) {
+        return
+      }
+    }
+  }
+}
+
 func Show(query string, index int, contextCategories []string) ([]string, []string) {
   filenames, contents := []string{}, []string{}
   for _, filename := range coparse.OrderedFiles {
-		filetype := strings.Split(filename, ".")
-		filetypeString := filetype[len(filetype)-1]
-		fileCategory := coparse.GetFileCategory(filetypeString)
+		fileCategory := coparse.FilenameCategories[filename]
     if strings.Contains(filename, query) && (len(contextCategories) == 0 || coutils.ContainsString(contextCategories, fileCategory)) {
       filenames = append(filenames, filename)
       contents = append(contents, coparse.FileOverview[filename][index])
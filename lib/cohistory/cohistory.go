@@ -0,0 +1,225 @@
+/* 
+** @name: cohistory
+** @author: Timo Kats
+** @description: Keeps the search history and the saved searches of a project (in $XDG_STATE_HOME/codis).
+*/
+
+package cohistory
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// globals
+
+var MaxEntries = 500
+var Modes = []string{"search", "fuzzy", "tree", "deps", "file", "symbol", "structural", "similar"}
+var Project string
+var History []Entry
+var Saved = make(map[string]Entry)
+
+// structs
+
+type Entry struct {
+	Query      string   `json:"query"`
+	Mode       string   `json:"mode"`
+	Categories []string `json:"categories,omitempty"`
+	Comments   bool     `json:"comments"`
+}
+
+/* 
+** @name: Directory
+** @description: Returns the directory with the state of a project ($XDG_STATE_HOME/codis/<name>-<hash> or ~/.local/state/codis/<name>-<hash>).
+*/
+func Directory(project string) string {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		stateHome = filepath.Join(home, ".local", "state")
+	}
+	sum := sha256.Sum256([]byte(project))
+	return filepath.Join(stateHome, "codis", filepath.Base(project) + "-" + hex.EncodeToString(sum[:])[:12])
+}
+
+/* 
+** @name: WriteFile
+** @description: Writes a file in the state directory of the project (through a temporary file, so it is never half written).
+*/
+func WriteFile(name string, content []byte) error {
+	directory := Directory(Project)
+	if directory == "" {
+		return errors.New("no state directory (set $XDG_STATE_HOME or $HOME)")
+	}
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+	temporary, err := os.CreateTemp(directory, name + ".*")
+	if err != nil {
+		return err
+	}
+	if _, err := temporary.Write(content); err != nil {
+		temporary.Close()
+		os.Remove(temporary.Name())
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		os.Remove(temporary.Name())
+		return err
+	}
+	return os.Rename(temporary.Name(), filepath.Join(directory, name))
+}
+
+/* 
+** @name: Open
+** @description: Loads the history and saved searches of a project (missing files are not an error).
+*/
+func Open(project string) error {
+	Project, History, Saved = project, []Entry{}, make(map[string]Entry)
+	directory := Directory(project)
+	if directory == "" {
+		return nil
+	}
+	if file, err := os.Open(filepath.Join(directory, "history")); err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64 * 1024), 1024 * 1024)
+		for scanner.Scan() {
+			var entry Entry
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Query != "" {
+				History = append(History, entry)
+			}
+		}
+		file.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	content, err := os.ReadFile(filepath.Join(directory, "searches.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, &Saved); err != nil {
+		Saved = make(map[string]Entry)
+		return fmt.Errorf("%s: %v", filepath.Join(directory, "searches.json"), err)
+	}
+	return nil
+}
+
+/* 
+** @name: Add
+** @description: Adds a search to the end of the history (an earlier copy is removed) and stores the history.
+*/
+func Add(entry Entry) error {
+	if strings.TrimSpace(entry.Query) == "" || MaxEntries == 0 {
+		return nil
+	}
+	history := []Entry{}
+	for _, previous := range History {
+		if previous.Query != entry.Query || previous.Mode != entry.Mode {
+			history = append(history, previous)
+		}
+	}
+	History = append(history, entry)
+	if len(History) > MaxEntries {
+		History = History[len(History)-MaxEntries:]
+	}
+	lines := []byte{}
+	for _, previous := range History {
+		line, err := json.Marshal(previous)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	return WriteFile("history", lines)
+}
+
+/* 
+** @name: Queries
+** @description: Returns the queries of a mode that start with a prefix, the most recent first.
+*/
+func Queries(mode string, prefix string) []string {
+	queries := []string{}
+	for index := len(History) - 1; index >= 0; index-- {
+		if History[index].Mode == mode && strings.HasPrefix(History[index].Query, prefix) && History[index].Query != prefix {
+			queries = append(queries, History[index].Query)
+		}
+	}
+	return queries
+}
+
+/* 
+** @name: writeSaved
+** @description: Stores the saved searches.
+*/
+func writeSaved() error {
+	content, err := json.MarshalIndent(Saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFile("searches.json", append(content, '\n'))
+}
+
+/* 
+** @name: Save
+** @description: Saves a search under a name (an existing search with that name is replaced).
+*/
+func Save(name string, entry Entry) error {
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	Saved[name] = entry
+	return writeSaved()
+}
+
+/* 
+** @name: Delete
+** @description: Removes a saved search.
+*/
+func Delete(name string) error {
+	if _, ok := Saved[name]; !ok {
+		return fmt.Errorf("no saved search named %q", name)
+	}
+	delete(Saved, name)
+	return writeSaved()
+}
+
+/* 
+** @name: Names
+** @description: Returns the names of the saved searches in alphabetical order.
+*/
+func Names() []string {
+	names := []string{}
+	for name := range Saved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/* 
+** @name: Describe
+** @description: Returns a one line description of a search (mode, query and filters).
+*/
+func Describe(entry Entry) string {
+	description := fmt.Sprintf("%-7s %s", entry.Mode, entry.Query)
+	if len(entry.Categories) > 0 {
+		description += "  [" + strings.Join(entry.Categories, ",") + "]"
+	}
+	if !entry.Comments {
+		description += "  [no comments]"
+	}
+	return description
+}
@@ -0,0 +1,349 @@
+/* 
+** @name: colsp
+** @author: Timo Kats
+** @description: Speaks the language server protocol (JSON-RPC) on top of the codis index.
+*/
+
+package colsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	engine "codis/engine"
+)
+
+// globals
+
+var symbolKinds = map[string]int{"function": 12, "object": 5}
+
+// structs
+
+type Message struct {
+	Jsonrpc string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *ResponseError   `json:"error,omitempty"`
+}
+
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+	Uri   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+type SymbolInformation struct {
+	Name     string   `json:"name"`
+	Kind     int      `json:"kind"`
+	Location Location `json:"location"`
+}
+
+type textDocumentParams struct {
+	TextDocument struct {
+		Uri string `json:"uri"`
+	} `json:"textDocument"`
+	Position Position `json:"position"`
+}
+
+type workspaceSymbolParams struct {
+	Query string `json:"query"`
+}
+
+// framing
+
+/* 
+** @name: ReadMessage
+** @description: Reads one (Content-Length framed) JSON-RPC message.
+*/
+func ReadMessage(reader *bufio.Reader) (*Message, error) {
+	contentLength := -1
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		header = strings.TrimSpace(header)
+		if header == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(header), "content-length:") {
+			contentLength, err = strconv.Atoi(strings.TrimSpace(header[len("content-length:"):]))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if contentLength < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	body := make([]byte, contentLength)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	message := &Message{}
+	return message, json.Unmarshal(body, message)
+}
+
+/* 
+** @name: WriteMessage
+** @description: Writes one (Content-Length framed) JSON-RPC message.
+*/
+func WriteMessage(writer io.Writer, message *Message) error {
+	message.Jsonrpc = "2.0"
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// helpers
+
+/* 
+** @name: pathToUri
+** @description: Converts an absolute path to a file uri.
+*/
+func pathToUri(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+/* 
+** @name: uriToPath
+** @description: Converts a file uri to an absolute path.
+*/
+func uriToPath(uri string) string {
+	if parsed, err := url.Parse(uri); err == nil && parsed.Scheme == "file" {
+		return filepath.FromSlash(parsed.Path)
+	}
+	return uri
+}
+
+/* 
+** @name: utf16Column
+** @description: Converts a byte offset in a line to an LSP character (the number of UTF-16 code units before it).
+*/
+func utf16Column(line string, offset int) int {
+	offset = max(0, min(offset, len(line)))
+	return len(utf16.Encode([]rune(line[:offset])))
+}
+
+/* 
+** @name: runeIndex
+** @description: Converts an LSP character (UTF-16 code units) in a line to the index of a rune.
+*/
+func runeIndex(runes []rune, character int) int {
+	index, units := 0, 0
+	for index < len(runes) && units < character {
+		units += len(utf16.Encode(runes[index:index+1]))
+		index++
+	}
+	return index
+}
+
+/* 
+** @name: wordAt
+** @description: Returns the identifier that surrounds a character (UTF-16 code units) in a line.
+*/
+func wordAt(line string, character int) string {
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' }
+	runes := []rune(line)
+	start := runeIndex(runes, max(0, character))
+	end := start
+	for start > 0 && isWord(runes[start-1]) {
+		start--
+	}
+	for end < len(runes) && isWord(runes[end]) {
+		end++
+	}
+	return string(runes[start:end])
+}
+
+/* 
+** @name: symbolInformation
+** @description: Converts a codis symbol to an LSP symbol.
+*/
+func symbolInformation(e *engine.Engine, symbol engine.Symbol) SymbolInformation {
+	symbol.FilePath = filepath.Join(e.Root, symbol.FilePath)
+	line, _ := e.Line(symbol.FilePath, symbol.Linenumber)
+	offset := strings.Index(line, symbol.Name)
+	if offset < 0 {
+		offset = 0
+	}
+	position := Range{Position{symbol.Linenumber - 1, utf16Column(line, offset)}, Position{symbol.Linenumber - 1, utf16Column(line, offset + len(symbol.Name))}}
+	return SymbolInformation{symbol.Name, symbolKinds[symbol.Kind], Location{pathToUri(symbol.FilePath), position}}
+}
+
+// handlers
+
+/* 
+** @name: workspaceSymbol
+** @description: Returns the symbols in the index whose name contains the query.
+*/
+func workspaceSymbol(e *engine.Engine, params workspaceSymbolParams) ([]SymbolInformation, error) {
+	symbols := []SymbolInformation{}
+	matches, err := e.Symbols(context.Background(), params.Query)
+	for _, symbol := range matches {
+		symbols = append(symbols, symbolInformation(e, symbol))
+	}
+	return symbols, err
+}
+
+/* 
+** @name: documentSymbol
+** @description: Returns the symbols that are declared in a document.
+*/
+func documentSymbol(e *engine.Engine, params textDocumentParams) ([]SymbolInformation, error) {
+	symbols := []SymbolInformation{}
+	path := uriToPath(params.TextDocument.Uri)
+	matches, err := e.Symbols(context.Background(), "")
+	for _, symbol := range matches {
+		if filepath.Join(e.Root, symbol.FilePath) == path {
+			symbols = append(symbols, symbolInformation(e, symbol))
+		}
+	}
+	return symbols, err
+}
+
+/* 
+** @name: definition
+** @description: Returns the declarations of the symbol under the cursor.
+*/
+func definition(e *engine.Engine, params textDocumentParams) ([]Location, error) {
+	locations := []Location{}
+	line, _ := e.Line(uriToPath(params.TextDocument.Uri), params.Position.Line + 1)
+	word := wordAt(line, params.Position.Character)
+	matches, err := e.Symbols(context.Background(), word)
+	for _, symbol := range matches {
+		if word != "" && symbol.Name == word {
+			locations = append(locations, symbolInformation(e, symbol).Location)
+		}
+	}
+	return locations, err
+}
+
+/* 
+** @name: references
+** @description: Returns every occurrence (as a whole word) of the symbol under the cursor. The references are textual, so other symbols with the same name are included.
+*/
+func references(e *engine.Engine, params textDocumentParams) ([]Location, error) {
+	locations := []Location{}
+	line, _ := e.Line(uriToPath(params.TextDocument.Uri), params.Position.Line + 1)
+	word := wordAt(line, params.Position.Character)
+	if word == "" {
+		return locations, nil
+	}
+	filter := engine.Filter{ExcludeComments: true}
+	err := e.SearchFunc(context.Background(), `\b` + regexp.QuoteMeta(word) + `\b`, filter, func(result engine.Result) bool {
+		position := Range{Position{result.Line - 1, utf16Column(result.Text, result.ColumnStart - 1)}, Position{result.Line - 1, utf16Column(result.Text, result.ColumnEnd - 1)}}
+		locations = append(locations, Location{pathToUri(filepath.Join(e.Root, result.Path)), position})
+		return true
+	})
+	return locations, err
+}
+
+/* 
+** @name: failed
+** @description: Turns the error of a handler into a response error (request failed).
+*/
+func failed(result interface{}, err error) (interface{}, *ResponseError) {
+	if err != nil {
+		return nil, &ResponseError{-32803, err.Error()}
+	}
+	return result, nil
+}
+
+/* 
+** @name: handle
+** @description: Returns the result (or error) of a request.
+*/
+func handle(e *engine.Engine, message *Message) (interface{}, *ResponseError) {
+	params := textDocumentParams{}
+	if message.Method != "workspace/symbol" && len(message.Params) > 0 {
+		if err := json.Unmarshal(message.Params, &params); err != nil {
+			return nil, &ResponseError{-32602, err.Error()}
+		}
+	}
+	switch message.Method {
+	case "initialize":
+		capabilities := map[string]interface{}{
+			"textDocumentSync": 0,
+			"workspaceSymbolProvider": true,
+			"documentSymbolProvider": true,
+			"definitionProvider": true,
+			"referencesProvider": true,
+		}
+		return map[string]interface{}{"capabilities": capabilities, "serverInfo": map[string]string{"name": "codis"}}, nil
+	case "shutdown":
+		return nil, nil
+	case "workspace/symbol":
+		symbolParams := workspaceSymbolParams{}
+		if err := json.Unmarshal(message.Params, &symbolParams); err != nil {
+			return nil, &ResponseError{-32602, err.Error()}
+		}
+		return failed(workspaceSymbol(e, symbolParams))
+	case "textDocument/documentSymbol":
+		return failed(documentSymbol(e, params))
+	case "textDocument/definition":
+		return failed(definition(e, params))
+	case "textDocument/references":
+		return failed(references(e, params))
+	}
+	return nil, &ResponseError{-32601, "method not found: " + message.Method}
+}
+
+/* 
+** @name: Serve
+** @description: Answers requests from in until the client exits.
+*/
+func Serve(e *engine.Engine, in io.Reader, out io.Writer) error {
+	reader := bufio.NewReader(in)
+	for {
+		message, err := ReadMessage(reader)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if message.Method == "exit" {
+			return nil
+		}
+		if message.Id == nil { // notifications don't get a response
+			continue
+		}
+		result, responseError := handle(e, message)
+		response := &Message{Id: message.Id, Result: result, Error: responseError}
+		if result == nil && responseError == nil {
+			response.Result = json.RawMessage("null")
+		}
+		if err := WriteMessage(out, response); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,164 @@
+/* 
+** @name: colsp_test
+** @author: Timo Kats
+** @description: Runs the language server in-process (over pipes) against a small project.
+*/
+
+package colsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	engine "codis/engine"
+)
+
+// globals
+
+var source = `package main
+
+func helper() int { return 1 }
+
+func main() {
+	greeting := "日本"; value := helper()
+	_, _ = greeting, value
+}
+`
+
+// structs
+
+type client struct {
+	t      *testing.T
+	writer io.Writer
+	reader *bufio.Reader
+	id     int
+}
+
+/* 
+** @name: request
+** @description: Sends a request to the server and returns the raw result of its response.
+*/
+func (c *client) request(method string, params interface{}) json.RawMessage {
+	c.t.Helper()
+	c.id++
+	id := json.RawMessage(strconv.Itoa(c.id))
+	body, _ := json.Marshal(params)
+	if err := WriteMessage(c.writer, &Message{Id: &id, Method: method, Params: body}); err != nil {
+		c.t.Fatalf("%s: %v", method, err)
+	}
+	response, err := ReadMessage(c.reader)
+	if err != nil {
+		c.t.Fatalf("%s: %v", method, err)
+	} else if response.Error != nil {
+		c.t.Fatalf("%s: %s", method, response.Error.Message)
+	}
+	result, _ := json.Marshal(response.Result)
+	return result
+}
+
+/* 
+** @name: TestServe
+** @description: Checks initialize, workspace/symbol, definition, references, shutdown and exit (positions are UTF-16 code units).
+*/
+func TestServe(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := engine.Open(root, engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(e, serverIn, serverOut)
+		serverOut.Close()
+	}()
+	c := &client{t: t, writer: clientOut, reader: bufio.NewReader(clientIn)}
+	uri := pathToUri(filepath.Join(root, "main.go"))
+	// the call to helper on line 5 starts after 日本, which is 6 bytes but 2 UTF-16 code units
+	call := Position{5, 28}
+
+	initialize := struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}{}
+	json.Unmarshal(c.request("initialize", map[string]interface{}{"rootUri": pathToUri(root)}), &initialize)
+	for _, capability := range []string{"workspaceSymbolProvider", "definitionProvider", "referencesProvider"} {
+		if initialize.Capabilities[capability] != true {
+			t.Errorf("initialize: %s is not enabled", capability)
+		}
+	}
+
+	symbols := []SymbolInformation{}
+	json.Unmarshal(c.request("workspace/symbol", map[string]string{"query": "help"}), &symbols)
+	expected := Location{uri, Range{Position{2, 5}, Position{2, 11}}}
+	if len(symbols) != 1 || symbols[0].Name != "helper" || symbols[0].Kind != 12 || symbols[0].Location != expected {
+		t.Errorf("workspace/symbol: got %+v, expected helper at %+v", symbols, expected)
+	}
+
+	position := map[string]interface{}{"textDocument": map[string]string{"uri": uri}, "position": call}
+	definitions := []Location{}
+	json.Unmarshal(c.request("textDocument/definition", position), &definitions)
+	if len(definitions) != 1 || definitions[0] != expected {
+		t.Errorf("textDocument/definition: got %+v, expected %+v", definitions, expected)
+	}
+
+	references := []Location{}
+	json.Unmarshal(c.request("textDocument/references", position), &references)
+	if len(references) != 2 || references[0] != expected || references[1] != (Location{uri, Range{call, Position{5, 34}}}) {
+		t.Errorf("textDocument/references: got %+v", references)
+	}
+
+	if result := c.request("shutdown", nil); string(result) != "null" {
+		t.Errorf("shutdown: got %s, expected null", result)
+	}
+	if err := WriteMessage(clientOut, &Message{Method: "exit"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("exit: %v", err)
+	}
+}
+
+/* 
+** @name: TestServeError
+** @description: Checks that a request whose search fails gets an error response instead of an empty result.
+*/
+func TestServeError(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := engine.Open(root, engine.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Open(root, engine.Options{}); err != nil { // replaces the index of e
+		t.Fatal(err)
+	}
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	go Serve(e, serverIn, serverOut)
+	reader := bufio.NewReader(clientIn)
+	for _, method := range []string{"workspace/symbol", "textDocument/documentSymbol"} {
+		id := json.RawMessage("1")
+		params, _ := json.Marshal(map[string]interface{}{"query": "helper", "textDocument": map[string]string{"uri": pathToUri(filepath.Join(root, "main.go"))}, "position": Position{5, 28}})
+		if err := WriteMessage(clientOut, &Message{Id: &id, Method: method, Params: params}); err != nil {
+			t.Fatal(err)
+		}
+		response, err := ReadMessage(reader)
+		if err != nil {
+			t.Fatal(err)
+		} else if response.Error == nil || response.Error.Code != -32803 {
+			t.Errorf("%s: got %+v, expected a request failed error", method, response)
+		}
+	}
+	clientOut.Close()
+}
@@ -7,11 +7,15 @@
 package coparse
 
 import (
-	"log"
+	"bytes"
+	"errors"
 	"os"
 	"fmt"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
+	"unicode"
 
 	coutils "codis/lib/coutils"
 	cotypes "codis/lib/cotypes"
@@ -20,52 +24,112 @@ import (
 // globals
 
 var codeStarted = false
+var Verbose = true
 var CurrentDirectory, _ = os.Getwd()
-var LabeledRows, OrderedKeys = ReturnLabels(CurrentDirectory)
+var PageSize = 15
 var InfoBoxCategories = []string{"types", "#functions", "#objects", "#web domains", "last query"}
-var Categories = ReturnCategories(LabeledRows, OrderedKeys)
-var TypeCountsFunction = ReturnTypeCounts(LabeledRows, OrderedKeys, "function")
-var TypeCountsObject = ReturnTypeCounts(LabeledRows, OrderedKeys, "object")
-var TypeCountsDomain = ReturnTypeCounts(LabeledRows, OrderedKeys, "domain")
-var QueryCounts = ReturnEmptyQueryResults()
-var Imports = ReturnImports(LabeledRows, OrderedKeys)
-var ContextCategories = ReturnUniqueCategories(OrderedKeys)
-var FileOverview, OrderedFiles = ReturnFileOverview()
-
-/* 
-** @name: readFile
-** @description: Returns a string of the filecontents given its name. 
-*/
-func readFile(Filename string) string {
-	fileContent, err := os.ReadFile(Filename)
-	if err != nil {
-		log.Fatal(err)
-	}
-	text := string(fileContent)
-	return text
+var BuiltinLabels = []string{"function", "object", "variable", "domain", "comment", "import"}
+var CustomLabels []cotypes.CustomLabel
+var LabelCounts map[string]map[string]int
+var FileCategories = map[string][]string{
+	"data": {"csv","tsv","json","jsonl","ndjson","sql","xml","proto","graphql","gql","avsc"},
+	"web": {"html","htm","css","scss","sass","less","erb","vue","svelte","ejs","hbs","jinja","j2","twig","astro"},
+	"code": {"rb","c","cc","cpp","cxx","py","pyi","js","mjs","cjs","jsx","ts","tsx","java","go","h","hh","hpp","hxx",
+		"rs","kt","kts","scala","swift","m","mm","cs","fs","vb","php","pl","pm","lua","r","jl","dart","ex","exs","erl",
+		"hrl","hs","ml","mli","clj","cljs","groovy","zig","nim","cr","elm","sol","vim","el","lisp","scm","tcl","awk",
+		"sh","bash","zsh","ksh","fish","ps1","bat","cmd"},
+	"config": {"yaml","yml","toml","ini","cfg","conf","env","properties","editorconfig","gitignore","gitattributes",
+		"dockerignore","npmrc","nvmrc","babelrc","eslintrc","prettierrc","tf","tfvars","hcl"},
+	"build": {"mk","make","cmake","gradle","bazel","bzl","nix","Makefile","makefile","GNUmakefile","Dockerfile",
+		"Containerfile","CMakeLists.txt","go.mod","go.sum","go.work","Cargo.toml","Cargo.lock","package.json",
+		"package-lock.json","yarn.lock","pnpm-lock.yaml","Gemfile","Gemfile.lock","Rakefile","Vagrantfile","Jenkinsfile",
+		"Procfile","BUILD","WORKSPACE","meson.build","justfile","Justfile","pom.xml","build.gradle","requirements.txt",
+		"setup.py","setup.cfg","pyproject.toml","docker-compose.yml","docker-compose.yaml"},
+	"compiled": {"dll","exe","so","dylib","o","a","class","jar","pyc","wasm"},
+	"textual": {"txt","md","markdown","rst","adoc","org","tex","in","log","README","LICENSE","CHANGELOG","AUTHORS","NOTICE","COPYING"},
+}
+var Interpreters = map[string]string{
+	"sh": "sh", "bash": "sh", "zsh": "sh", "dash": "sh", "ksh": "sh", "fish": "fish", "python": "py", "pypy": "py",
+	"node": "js", "deno": "ts", "bun": "ts", "ruby": "rb", "perl": "pl", "php": "php", "lua": "lua", "Rscript": "r",
+	"awk": "awk", "gawk": "awk", "tclsh": "tcl", "make": "mk",
 }
+var LabeledRows map[cotypes.RowLabel]string
+var OrderedKeys []cotypes.RowLabel
+var Categories map[string]string
+var TypeCountsFunction map[string]int
+var TypeCountsObject map[string]int
+var TypeCountsDomain map[string]int
+var QueryCounts map[string]int
+var Imports map[string][]string
+var Symbols []cotypes.Symbol
+var ContextCategories []string
+var FileOverview map[string][]string
+var FilenameCategories map[string]string
+var ModTimes = make(map[string]time.Time)
+var OrderedFiles []string
 
 // labeling functions
 
 /* 
-** @name: getFileCategory
-** @description: Assigns a category to a file based on its extension 
+** @name: lookupCategory
+** @description: Returns the category that lists a filename or extension (or an empty string).
 */
-func GetFileCategory(currentExtension string) string {
-	var categories = make(map[string][]string)
-	categories["data"] = []string{"csv","json","sql","xml"}
-	categories["web"] = []string{"html","css","scss", "erb"}
-	categories["code"] = []string{"rb","c","cc","cpp","py","js","java","go", "h"}
-	categories["compiled"] = []string{"dll","exe"}
-	categories["textual"] = []string{"txt","md", "in"}
-	for Category, extensions := range categories {
+func lookupCategory(name string) string {
+	for Category, extensions := range FileCategories {
 		for  _,extension := range extensions {
-			if extension == currentExtension {
+			if extension == name {
 				return Category
 			}
 		}
 	}
-	return "undefined"
+	return ""
+}
+
+/* 
+** @name: shebangType
+** @description: Returns the file type of the interpreter in a shebang line (e.g. "#!/usr/bin/env python3" is py).
+*/
+func shebangType(firstLine string) string {
+	fields := strings.Fields(strings.TrimPrefix(firstLine, "#!"))
+	if !strings.HasPrefix(firstLine, "#!") || len(fields) == 0 {
+		return ""
+	}
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" {
+		interpreter = ""
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") && !strings.Contains(field, "=") {
+				interpreter = field
+				break
+			}
+		}
+	}
+	if filetype, ok := Interpreters[interpreter]; ok {
+		return filetype
+	}
+	return Interpreters[strings.TrimRight(interpreter, "0123456789.")]
+}
+
+/* 
+** @name: GetFileCategory
+** @description: Assigns a category and file type to a file based on its name, its extension or its shebang line.
+*/
+func GetFileCategory(file string, text string) (string, string) {
+	Filetype := strings.Split(file, ".")
+	FiletypeString := Filetype[len(Filetype)-1]
+	if category := lookupCategory(file); category != "" {
+		return category, FiletypeString
+	}
+	if category := lookupCategory(FiletypeString); category != "" && len(Filetype) > 1 {
+		return category, FiletypeString
+	}
+	firstLine, _, _ := strings.Cut(text, "\n")
+	if shebang := shebangType(strings.TrimSpace(firstLine)); shebang != "" {
+		if category := lookupCategory(shebang); category != "" {
+			return category, shebang
+		}
+	}
+	return "undefined", FiletypeString
 }
 
 /* 
@@ -82,6 +146,93 @@ func hasDomain(row string) bool {
 	return false
 }
 
+/* 
+** @name: customLabels
+** @description: Returns a bitmask with a bit for each custom label whose pattern matches the line.
+*/
+func customLabels(row string) uint64 {
+	var mask uint64
+	for index, label := range CustomLabels {
+		if label.Pattern.MatchString(row) {
+			mask |= 1 << index
+		}
+	}
+	return mask
+}
+
+/* 
+** @name: SetCustomLabel
+** @description: Adds, changes or (with an empty pattern) removes a custom label, an invalid pattern leaves the label as it was. The index has to be rebuilt afterwards.
+*/
+func SetCustomLabel(name string, pattern string) error {
+	if !regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`).MatchString(name) || coutils.ContainsString(BuiltinLabels, name) {
+		return fmt.Errorf("invalid label name %q", name)
+	}
+	position := -1
+	for index, label := range CustomLabels {
+		if label.Name == name {
+			position = index
+		}
+	}
+	if pattern == "" {
+		if position != -1 {
+			CustomLabels = append(CustomLabels[:position], CustomLabels[position+1:]...)
+		}
+		return nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern for label %s: %v", name, err)
+	}
+	if position != -1 {
+		CustomLabels[position].Pattern = compiled
+	} else if len(CustomLabels) == 64 {
+		return fmt.Errorf("too many labels (at most 64)")
+	} else {
+		CustomLabels = append(CustomLabels, cotypes.CustomLabel{Name: name, Pattern: compiled})
+	}
+	return nil
+}
+
+/* 
+** @name: HasLabel
+** @description: Returns true if a row has a (built-in or custom) label.
+*/
+func HasLabel(key cotypes.RowLabel, label string) bool {
+	switch label {
+		case "function":
+			return key.HasFunction
+		case "object":
+			return key.HasObject
+		case "variable":
+			return key.HasVariableDeclaration
+		case "domain":
+			return key.HasDomain
+		case "comment":
+			return key.HasComment
+		case "import":
+			return key.ImportedCode != ""
+	}
+	for index, customLabel := range CustomLabels {
+		if customLabel.Name == label {
+			return key.CustomLabels & (1 << index) != 0
+		}
+	}
+	return false
+}
+
+/* 
+** @name: LabelNames
+** @description: Returns the names of the built-in and custom labels.
+*/
+func LabelNames() []string {
+	names := append([]string{}, BuiltinLabels...)
+	for _, label := range CustomLabels {
+		names = append(names, label.Name)
+	}
+	return names
+}
+
 func getImportedFile(line string, paths []string, files []string) string {
 	for index, file := range files { 
 		if strings.Contains(line, file) || strings.Contains(line, strings.Split(file, ".")[0]) {
@@ -191,25 +342,100 @@ func hasFunction(row string, fileCategory string) bool {
 
 /* 
 ** @name: iterate
-** @description: Walks through all files in the child directories and returns their contents
+** @description: Walks through all files in the child directories and returns their contents (and the errors of the paths that couldn't be read, which are skipped)
 */
-func iterate(path string) ([]string, []string, []string) {
+func iterate(path string) ([]string, []string, []string, []error) {
 	var texts []string
 	var files []string
 	var paths []string
+	var errs []error
 	filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			fmt.Print("codis parsing error: ")
-			log.Fatalf(err.Error())
+			errs = append(errs, err)
+			return nil
 		}
-		if !info.IsDir() && strings.Contains(info.Name(), ".") && !strings.Contains(path, ".exe") && !strings.Contains(path, ".git") { 
-			texts = append(texts, readFile(path))
-			files = append(files, info.Name())
-			paths = append(paths, path)
+		if !info.IsDir() && !strings.Contains(path, ".exe") && !strings.Contains(path, ".git") { 
+			if text, ok := indexable(path, info); ok {
+				texts = append(texts, text)
+				files = append(files, info.Name())
+				paths = append(paths, path)
+			}
 		}
 		return nil
 	})
-	return texts, files, paths
+	return texts, files, paths, errs
+}
+
+/* 
+** @name: indexable
+** @description: Returns the contents of a file if it should be indexed (no sockets, pipes or devices, and no binary files unless their category is known) and remembers when it was modified.
+*/
+func indexable(path string, info os.FileInfo) (string, bool) {
+	if info.Mode() & os.ModeSymlink != 0 {
+		target, err := os.Stat(path)
+		if err != nil {
+			return "", false
+		}
+		info = target
+	}
+	if !info.Mode().IsRegular() {
+		return "", false
+	}
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	text := string(fileContent)
+	head := fileContent
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	if category, _ := GetFileCategory(info.Name(), text); category == "undefined" && bytes.IndexByte(head, 0) != -1 {
+		return "", false
+	}
+	ModTimes[path] = info.ModTime()
+	return text, true
+}
+
+/* 
+** @name: labelFile
+** @description: Creates a rowlabel object for each line of one file (files/paths are used to find its imports).
+*/
+func labelFile(text string, file string, filePath string, files []string, paths []string, labeledRows map[cotypes.RowLabel]string) []cotypes.RowLabel {
+	orderedKeys := []cotypes.RowLabel{}
+	// attributes that are the same for all lines
+	fileCategory, FiletypeString := GetFileCategory(file, text)
+	codeStarted = false
+	for lineIndex, line := range strings.Split(text, "\n") {
+		HasVariableDeclaration := hasVariableDeclaration(line, fileCategory)
+		HasObject := hasObject(line, fileCategory)
+		hasFunction := hasFunction(line, fileCategory)
+		HasDomain := hasDomain(line)
+		HasComment := hasComment(line)
+		importedCode := importedCode(line, HasComment, files, paths, fileCategory)
+		// create key 
+		key := cotypes.RowLabel{
+			Filename: file, 
+			Linenumber: lineIndex+1, 
+			Filetype: FiletypeString, 
+			HasVariableDeclaration: HasVariableDeclaration,
+			CustomLabels: customLabels(line),
+			HasFunction: hasFunction, 
+			HasObject: HasObject, 
+			HasDomain: HasDomain, 
+			Category: fileCategory,
+			HasComment: HasComment, 
+			FilePath: filePath,
+			ImportedCode: importedCode,
+		}
+		// create return values
+		labeledRows[key] = line
+		orderedKeys = append(orderedKeys, key)
+	}
+	if Verbose {
+		fmt.Println("Parsed: ", file)
+	}
+	return orderedKeys
 }
 
 /* 
@@ -220,52 +446,93 @@ func labelRows(texts []string, files []string, paths []string) (map[cotypes.RowL
 	labeledRows := make(map[cotypes.RowLabel]string)
 	orderedKeys := []cotypes.RowLabel{}
 	for fileIndex, text := range texts {
-		// attributes that are the same for all files
-		Filetype := strings.Split(files[fileIndex], ".")
-		FiletypeString := Filetype[len(Filetype)-1]
-		fileCategory := GetFileCategory(FiletypeString)
-		FilePath := paths[fileIndex]
-		codeStarted = false
-		for lineIndex, line := range strings.Split(text, "\n") {
-			HasVariableDeclaration := hasVariableDeclaration(line, fileCategory)
-			HasObject := hasObject(line, fileCategory)
-			hasFunction := hasFunction(line, fileCategory)
-			HasDomain := hasDomain(line)
-			HasComment := hasComment(line)
-			importedCode := importedCode(line, HasComment, files, paths, fileCategory)
-			// create key 
-			key := cotypes.RowLabel{
-				Filename: files[fileIndex], 
-				Linenumber: lineIndex+1, 
-				Filetype: FiletypeString, 
-				HasVariableDeclaration: HasVariableDeclaration,
-				HasFunction: hasFunction, 
-				HasObject: HasObject, 
-				HasDomain: HasDomain, 
-				Category: fileCategory,
-				HasComment: HasComment, 
-				FilePath: FilePath,
-				ImportedCode: importedCode,
-			}
-			// create return values
-			labeledRows[key] = line
-			orderedKeys = append(orderedKeys, key)
-		}
-		fmt.Println("Parsed: ", files[fileIndex])
+		orderedKeys = append(orderedKeys, labelFile(text, files[fileIndex], paths[fileIndex], files, paths, labeledRows)...)
 	}
 	return labeledRows, orderedKeys
 }
 
 // caller functions
 
+/* 
+** @name: Reindex
+** @description: Parses all files in a directory and (re)computes the globals that are derived from it. Paths that can't be read are skipped and returned as one error.
+*/
+func Reindex(directory string) error {
+	var err error
+	CurrentDirectory = directory
+	ModTimes = make(map[string]time.Time)
+	LabeledRows, OrderedKeys, err = ReturnLabels(CurrentDirectory)
+	deriveGlobals()
+	return err
+}
+
+/* 
+** @name: ReindexFile
+** @description: Parses one (edited, created or removed) file again and updates the globals that are derived from it.
+*/
+func ReindexFile(filePath string) {
+	files, paths := []string{}, []string{}
+	orderedKeys := []cotypes.RowLabel{}
+	position := -1
+	for _, key := range OrderedKeys {
+		if key.FilePath == filePath {
+			if position == -1 {
+				position = len(orderedKeys)
+			}
+			delete(LabeledRows, key)
+			continue
+		} else if len(paths) == 0 || paths[len(paths)-1] != key.FilePath {
+			files = append(files, key.Filename)
+			paths = append(paths, key.FilePath)
+		}
+		orderedKeys = append(orderedKeys, key)
+	}
+	fileKeys := []cotypes.RowLabel{}
+	delete(ModTimes, filePath)
+	if info, err := os.Lstat(filePath); err == nil {
+		if text, ok := indexable(filePath, info); ok {
+			if position == -1 {
+				position = len(orderedKeys)
+			}
+			files = append(files, filepath.Base(filePath))
+			paths = append(paths, filePath)
+			fileKeys = labelFile(text, filepath.Base(filePath), filePath, files, paths, LabeledRows)
+		}
+	}
+	if position != -1 {
+		orderedKeys = append(orderedKeys[:position], append(fileKeys, orderedKeys[position:]...)...)
+	}
+	OrderedKeys = orderedKeys
+	deriveGlobals()
+}
+
+/* 
+** @name: deriveGlobals
+** @description: (Re)computes the globals that are derived from the labeled rows.
+*/
+func deriveGlobals() {
+	Categories = ReturnCategories(LabeledRows, OrderedKeys)
+	TypeCountsFunction = ReturnTypeCounts(LabeledRows, OrderedKeys, "function")
+	TypeCountsObject = ReturnTypeCounts(LabeledRows, OrderedKeys, "object")
+	TypeCountsDomain = ReturnTypeCounts(LabeledRows, OrderedKeys, "domain")
+	LabelCounts = ReturnLabelCounts(OrderedKeys)
+	InfoBoxCategories = ReturnInfoBoxCategories()
+	QueryCounts = ReturnEmptyQueryResults()
+	Imports = ReturnImports(LabeledRows, OrderedKeys)
+	Symbols = ReturnSymbols(LabeledRows, OrderedKeys)
+	ContextCategories = ReturnUniqueCategories(OrderedKeys)
+	FilenameCategories = ReturnFilenameCategories(OrderedKeys)
+	FileOverview, OrderedFiles = ReturnFileOverview()
+}
+
 /* 
 ** @name: ReturnLabels
-** @description: Returns the rowlabel objects and their order.
+** @description: Returns the rowlabel objects and their order (and the errors of the paths that were skipped).
 */
-func ReturnLabels(directory string) (map[cotypes.RowLabel]string, []cotypes.RowLabel) {
-	texts, files, paths := iterate(directory)
+func ReturnLabels(directory string) (map[cotypes.RowLabel]string, []cotypes.RowLabel, error) {
+	texts, files, paths, errs := iterate(directory)
 	labeledRows, orderedKeys := labelRows(texts, files, paths)
-	return labeledRows, orderedKeys
+	return labeledRows, orderedKeys, errors.Join(errs...)
 }
 
 /* 
@@ -314,6 +581,46 @@ func ReturnTypeCounts(labeledRows map[cotypes.RowLabel]string, orderedKeys []cot
 	return counts 
 }
 
+/* 
+** @name: ReturnLabelCounts
+** @description: Returns the number of lines per file for each custom label.
+*/
+func ReturnLabelCounts(orderedKeys []cotypes.RowLabel) map[string]map[string]int {
+	counts := make(map[string]map[string]int)
+	for index, label := range CustomLabels {
+		counts[label.Name] = make(map[string]int)
+		for _, key := range orderedKeys {
+			if key.CustomLabels & (1 << index) != 0 {
+				counts[label.Name][key.FilePath] += 1
+			}
+		}
+	}
+	return counts
+}
+
+/* 
+** @name: InfoBoxCount
+** @description: Returns the count of a custom label column (the columns after "last query") of the info box.
+*/
+func InfoBoxCount(infoIndex int, path string) (int, bool) {
+	if infoIndex < 5 || infoIndex >= 5 + len(CustomLabels) {
+		return 0, false
+	}
+	return LabelCounts[CustomLabels[infoIndex-5].Name][path], true
+}
+
+/* 
+** @name: ReturnInfoBoxCategories
+** @description: Returns the columns of the info box (one extra column per custom label).
+*/
+func ReturnInfoBoxCategories() []string {
+	categories := []string{"types", "#functions", "#objects", "#web domains", "last query"}
+	for _, label := range CustomLabels {
+		categories = append(categories, "#" + label.Name)
+	}
+	return categories
+}
+
 func ReturnImports(labeledRows map[cotypes.RowLabel]string, orderedKeys []cotypes.RowLabel) map[string][]string {
 	imports := make(map[string][]string)
 	for _, key := range orderedKeys {
@@ -340,6 +647,88 @@ func ReturnUniqueCategories(orderedKeys []cotypes.RowLabel) []string {
 	return uniqueFileTypes
 }
 
+/* 
+** @name: ReturnFilenameCategories
+** @description: Returns a map with the category for each filename.
+*/
+func ReturnFilenameCategories(orderedKeys []cotypes.RowLabel) map[string]string {
+	categories := make(map[string]string)
+	for _, key := range orderedKeys {
+		if _, ok := categories[key.Filename]; !ok {
+			categories[key.Filename] = key.Category
+		}
+	}
+	return categories
+}
+
+/* 
+** @name: GetSymbolName
+** @description: Returns the name of the function/object declared on a line (or an empty string). 
+*/
+func GetSymbolName(line string, hasFunction bool, hasObject bool) string {
+	declaritiveKeywords := []string{"type ", "class ", "struct ", "enum "}
+	if hasFunction {
+		declaritiveKeywords = []string{"func ", "def ", "fun ", "fn "}
+	} else if !hasObject {
+		return ""
+	}
+	for _, declaritiveKeyword := range declaritiveKeywords {
+		start := strings.Index(line, declaritiveKeyword)
+		if start == -1 || (start > 0 && line[start-1] != ' ' && line[start-1] != '\t') {
+			continue
+		}
+		rest := strings.TrimSpace(line[start+len(declaritiveKeyword):])
+		receiver := strings.HasPrefix(rest, "(") && strings.Contains(rest, ")")
+		if receiver { // go method
+			rest = strings.TrimSpace(rest[strings.Index(rest, ")")+1:])
+		}
+		name := ""
+		for _, char := range rest {
+			if !unicode.IsLetter(char) && !unicode.IsDigit(char) && char != '_' {
+				break
+			}
+			name += string(char)
+		}
+		if receiver && !strings.HasPrefix(rest[len(name):], "(") {
+			return "" // function type, not a declaration
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+/* 
+** @name: ReturnSymbols
+** @description: Returns the functions and objects that are declared in the code files.
+*/
+func ReturnSymbols(labeledRows map[cotypes.RowLabel]string, orderedKeys []cotypes.RowLabel) []cotypes.Symbol {
+	symbols := []cotypes.Symbol{}
+	for index, key := range orderedKeys {
+		if key.Category != "code" || key.HasComment || !(key.HasFunction || key.HasObject) {
+			continue
+		}
+		name := GetSymbolName(labeledRows[key], key.HasFunction, key.HasObject)
+		if name == "" {
+			continue
+		}
+		kind := "object"
+		if key.HasFunction {
+			kind = "function"
+		}
+		symbols = append(symbols, cotypes.Symbol{
+			Name: name,
+			Kind: kind,
+			Filename: key.Filename,
+			FilePath: key.FilePath,
+			Linenumber: key.Linenumber,
+			Index: index,
+		})
+	}
+	return symbols
+}
+
 /* not for this version
 func ReturnIndex() map[string][]cotypes.IndexLabel {
   invertedIndex := make(map[string][]cotypes.IndexLabel)
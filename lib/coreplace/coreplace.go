@@ -0,0 +1,295 @@
+/* 
+** @name: coreplace
+** @author: Timo Kats
+** @description: Replaces the matches of a quick search, either in the files themselves or as a patch.
+*/
+
+package coreplace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	coparse "codis/lib/coparse"
+	cosearch "codis/lib/cosearch"
+	cotypes "codis/lib/cotypes"
+)
+
+// globals
+
+var ContextLines = 3
+var PatchFile = "codis.patch"
+
+// structs
+
+type Hit struct {
+	Path     string `json:"path"` // relative to the project
+	Line     int    `json:"line"`
+	Old      string `json:"old"`
+	New      string `json:"new"`
+	Accepted bool   `json:"accepted"`
+}
+
+/* 
+** @name: Absolute
+** @description: Returns the absolute path of a file of the project.
+*/
+func Absolute(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(coparse.CurrentDirectory, path)
+}
+
+/* 
+** @name: Plan
+** @description: Returns a (accepted) hit for every result whose line changes when the matches of the pattern are replaced ($1 and ${name} refer to groups).
+*/
+func Plan(pattern string, replacement string, results []cotypes.Result) ([]Hit, error) {
+	pattern, _, err := cosearch.ParseKinds(pattern)
+	if err != nil {
+		return nil, err
+	}
+	rePattern, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	hits, seen := []Hit{}, make(map[int]bool)
+	for _, result := range results {
+		if result.Index < 0 || result.Index >= len(coparse.OrderedKeys) || seen[result.Index] {
+			continue
+		}
+		seen[result.Index] = true
+		key := coparse.OrderedKeys[result.Index]
+		line := coparse.LabeledRows[key]
+		if replaced := rePattern.ReplaceAllString(line, replacement); strings.ContainsAny(replaced, "\r\n") && !strings.ContainsAny(line, "\r\n") {
+			return nil, fmt.Errorf("the replacement of %s:%d spans more than one line", result.Path, key.Linenumber)
+		} else if replaced != line {
+			hits = append(hits, Hit{result.Path, key.Linenumber, line, replaced, true})
+		}
+	}
+	return hits, nil
+}
+
+/* 
+** @name: Accepted
+** @description: Returns the number of accepted hits.
+*/
+func Accepted(hits []Hit) int {
+	accepted := 0
+	for _, hit := range hits {
+		if hit.Accepted {
+			accepted++
+		}
+	}
+	return accepted
+}
+
+/* 
+** @name: byFile
+** @description: Groups the accepted hits by file (in the order of the hits).
+*/
+func byFile(hits []Hit) ([]string, map[string][]Hit) {
+	paths, files := []string{}, make(map[string][]Hit)
+	for _, hit := range hits {
+		if !hit.Accepted {
+			continue
+		}
+		if _, ok := files[hit.Path]; !ok {
+			paths = append(paths, hit.Path)
+		}
+		files[hit.Path] = append(files[hit.Path], hit)
+	}
+	return paths, files
+}
+
+/* 
+** @name: Modified
+** @description: Returns true if a file was changed (or removed) after it was indexed.
+*/
+func Modified(path string) bool {
+	indexed, ok := coparse.ModTimes[Absolute(path)]
+	info, err := os.Stat(Absolute(path))
+	return !ok || err != nil || !info.ModTime().Equal(indexed)
+}
+
+/* 
+** @name: changeLines
+** @description: Returns the lines of a file before and after replacing the hits, and whether it ends with a newline.
+*/
+func changeLines(path string, hits []Hit) ([]string, []string, bool, error) {
+	if Modified(path) {
+		return nil, nil, false, fmt.Errorf("%s: modified since indexing", path)
+	}
+	content, err := os.ReadFile(Absolute(path))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	text := string(content)
+	newline := strings.HasSuffix(text, "\n")
+	oldLines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if text == "" {
+		oldLines = []string{}
+	}
+	newLines := append([]string{}, oldLines...)
+	for _, hit := range hits {
+		if hit.Line < 1 || hit.Line > len(oldLines) || oldLines[hit.Line-1] != hit.Old {
+			return nil, nil, false, fmt.Errorf("%s:%d: line differs from the index", path, hit.Line)
+		}
+		newLines[hit.Line-1] = hit.New
+	}
+	return oldLines, newLines, newline, nil
+}
+
+/* 
+** @name: writeAtomic
+** @description: Replaces the contents of a file through a temporary file in the same directory (keeping its permissions). A symlink is followed, so its target is written and the link stays.
+*/
+func writeAtomic(path string, content string) error {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	temporary, err := os.CreateTemp(filepath.Dir(path), "." + filepath.Base(path) + ".codis-*")
+	if err != nil {
+		return err
+	}
+	if _, err := temporary.WriteString(content); err != nil {
+		temporary.Close()
+		os.Remove(temporary.Name())
+		return err
+	}
+	if err := temporary.Chmod(info.Mode().Perm()); err != nil {
+		temporary.Close()
+		os.Remove(temporary.Name())
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		os.Remove(temporary.Name())
+		return err
+	}
+	return os.Rename(temporary.Name(), path)
+}
+
+/* 
+** @name: Apply
+** @description: Writes the accepted hits to their files. Returns the files that were written and the errors of the files that were skipped.
+** @note: Files that were modified after indexing (or whose lines differ from the index) are never written.
+*/
+func Apply(hits []Hit) ([]string, []error) {
+	written, errs := []string{}, []error{}
+	paths, files := byFile(hits)
+	for _, path := range paths {
+		_, newLines, newline, err := changeLines(path, files[path])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		content := strings.Join(newLines, "\n")
+		if newline {
+			content += "\n"
+		}
+		if err := writeAtomic(Absolute(path), content); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		written = append(written, path)
+	}
+	return written, errs
+}
+
+/* 
+** @name: diffLine
+** @description: Returns a line of a unified diff (with a marker if it is the last line of a file without a newline).
+*/
+func diffLine(prefix string, line string, last bool) string {
+	if last {
+		return prefix + line + "\n\\ No newline at end of file\n"
+	}
+	return prefix + line + "\n"
+}
+
+/* 
+** @name: unifiedDiff
+** @description: Returns the unified diff of a file whose lines were replaced (the number of lines stays the same).
+*/
+func unifiedDiff(path string, oldLines []string, newLines []string, newline bool) string {
+	hunks := [][2]int{}
+	for index := range oldLines {
+		if oldLines[index] == newLines[index] {
+			continue
+		}
+		start, end := max(0, index - ContextLines), min(len(oldLines) - 1, index + ContextLines)
+		if len(hunks) > 0 && start <= hunks[len(hunks)-1][1] + 1 {
+			hunks[len(hunks)-1][1] = end
+		} else {
+			hunks = append(hunks, [2]int{start, end})
+		}
+	}
+	if len(hunks) == 0 {
+		return ""
+	}
+	name := filepath.ToSlash(path)
+	diff := fmt.Sprintf("--- a/%s\n+++ b/%s\n", name, name)
+	last := len(oldLines) - 1
+	for _, hunk := range hunks {
+		diff += fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", hunk[0] + 1, hunk[1] - hunk[0] + 1, hunk[0] + 1, hunk[1] - hunk[0] + 1)
+		for index := hunk[0]; index <= hunk[1]; {
+			if oldLines[index] == newLines[index] {
+				diff += diffLine(" ", oldLines[index], index == last && !newline)
+				index++
+				continue
+			}
+			end := index
+			for end <= hunk[1] && oldLines[end] != newLines[end] {
+				end++
+			}
+			for changed := index; changed < end; changed++ {
+				diff += diffLine("-", oldLines[changed], changed == last && !newline)
+			}
+			for changed := index; changed < end; changed++ {
+				diff += diffLine("+", newLines[changed], changed == last && !newline)
+			}
+			index = end
+		}
+	}
+	return diff
+}
+
+/* 
+** @name: Patch
+** @description: Returns the accepted hits as a unified diff (apply with git apply or patch -p1 in the project) and the errors of the files that were skipped.
+*/
+func Patch(hits []Hit) (string, []error) {
+	patch, errs := "", []error{}
+	paths, files := byFile(hits)
+	for _, path := range paths {
+		oldLines, newLines, newline, err := changeLines(path, files[path])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		patch += unifiedDiff(path, oldLines, newLines, newline)
+	}
+	return patch, errs
+}
+
+/* 
+** @name: Describe
+** @description: Returns a hit as its location and the old and new line (without their shared indentation).
+*/
+func Describe(hit Hit) (string, string, string) {
+	indent := len(hit.Old) - len(strings.TrimLeft(hit.Old, " \t"))
+	oldLine, newLine := hit.Old, hit.New
+	if strings.HasPrefix(newLine, oldLine[:indent]) {
+		oldLine, newLine = oldLine[indent:], newLine[indent:]
+	}
+	return fmt.Sprintf("%s:%d", hit.Path, hit.Line), oldLine, newLine
+}
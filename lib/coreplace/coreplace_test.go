@@ -0,0 +1,178 @@
+/* 
+** @name: coreplace_test
+** @author: Timo Kats
+** @description: Plans, applies and diffs replacements in a temporary project.
+*/
+
+package coreplace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	coparse "codis/lib/coparse"
+	cosearch "codis/lib/cosearch"
+	cotypes "codis/lib/cotypes"
+)
+
+/* 
+** @name: project
+** @description: Indexes a temporary project with files (name to contents) and returns its directory.
+*/
+func project(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := coparse.Reindex(root); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+/* 
+** @name: plan
+** @description: Runs a quick search and plans the replacement of its matches.
+*/
+func plan(t *testing.T, pattern string, replacement string) ([]Hit, error) {
+	results := []cotypes.Result{}
+	if err := cosearch.BasicSearch(pattern, nil, true, func(result cotypes.Result) bool {
+		results = append(results, result)
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	return Plan(pattern, replacement, results)
+}
+
+/* 
+** @name: TestPlan
+** @description: Checks the hits of replacements (with groups) and that a replacement may not add lines.
+*/
+func TestPlan(t *testing.T) {
+	project(t, map[string]string{"a.go": "package a\nfunc Foo() {}\nvar x = Foo\n"})
+	tests := []struct {
+		pattern     string
+		replacement string
+		hits        []string // path:line:new
+		invalid     bool
+	}{
+		{"Foo", "Bar", []string{"a.go:2:func Bar() {}", "a.go:3:var x = Bar"}, false},
+		{`func (\w+)`, "func New$1", []string{"a.go:2:func NewFoo() {}"}, false},
+		{"Foo", "Foo", []string{}, false},
+		{"Foo", "A\nB", nil, true},
+		{"(", "x", nil, true},
+	}
+	for _, test := range tests {
+		hits, err := plan(t, test.pattern, test.replacement)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%q -> %q: expected an error", test.pattern, test.replacement)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q -> %q: %v", test.pattern, test.replacement, err)
+			continue
+		}
+		described := []string{}
+		for _, hit := range hits {
+			described = append(described, fmt.Sprintf("%s:%d:%s", hit.Path, hit.Line, hit.New))
+		}
+		if !reflect.DeepEqual(described, test.hits) {
+			t.Errorf("%q -> %q: got %v, expected %v", test.pattern, test.replacement, described, test.hits)
+		}
+	}
+}
+
+/* 
+** @name: TestApply
+** @description: Checks that accepted hits are written (also through a symlink) and that files changed since indexing are skipped.
+*/
+func TestApply(t *testing.T) {
+	root := project(t, map[string]string{"a.go": "package a\nvar x = Foo", "b.go": "package b\nvar y = Foo\n"})
+	if err := os.Symlink("b.go", filepath.Join(root, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := coparse.Reindex(root); err != nil {
+		t.Fatal(err)
+	}
+	hits, err := plan(t, "Foo", "Bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index := range hits {
+		hits[index].Accepted = hits[index].Path != "b.go" // b.go is written through the link
+	}
+	stale := filepath.Join(root, "a.go")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(stale, later, later); err != nil { // as if another program changed it
+		t.Fatal(err)
+	}
+	written, errs := Apply(hits)
+	if !reflect.DeepEqual(written, []string{"link.go"}) {
+		t.Errorf("written: got %v, expected [link.go]", written)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "a.go: modified since indexing") {
+		t.Errorf("errors: got %v, expected a.go to be skipped", errs)
+	}
+	for name, expected := range map[string]string{"a.go": "package a\nvar x = Foo", "b.go": "package b\nvar y = Bar\n"} {
+		if content, _ := os.ReadFile(filepath.Join(root, name)); string(content) != expected {
+			t.Errorf("%s: got %q, expected %q", name, content, expected)
+		}
+	}
+	if info, err := os.Lstat(filepath.Join(root, "link.go")); err != nil || info.Mode() & os.ModeSymlink == 0 {
+		t.Errorf("link.go is no longer a symlink")
+	}
+}
+
+/* 
+** @name: TestUnifiedDiff
+** @description: Checks the hunk headers of diffs (context lines, merged hunks and the missing newline marker).
+*/
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(count int) []string {
+		numbered := []string{}
+		for line := 1; line <= count; line++ {
+			numbered = append(numbered, fmt.Sprint(line))
+		}
+		return numbered
+	}
+	tests := []struct {
+		length  int
+		changed []int // 1-based
+		newline bool
+		headers []string
+	}{
+		{10, []int{1}, true, []string{"@@ -1,4 +1,4 @@"}},
+		{10, []int{5}, true, []string{"@@ -2,7 +2,7 @@"}},
+		{10, []int{2, 9}, true, []string{"@@ -1,10 +1,10 @@"}}, // the context of both changes touches
+		{20, []int{2, 13}, true, []string{"@@ -1,5 +1,5 @@", "@@ -10,7 +10,7 @@"}},
+		{20, []int{20}, false, []string{"@@ -17,4 +17,4 @@"}},
+	}
+	for _, test := range tests {
+		oldLines, newLines := lines(test.length), lines(test.length)
+		for _, line := range test.changed {
+			newLines[line-1] = "changed"
+		}
+		diff := unifiedDiff("a.go", oldLines, newLines, test.newline)
+		headers := []string{}
+		for _, line := range strings.Split(diff, "\n") {
+			if strings.HasPrefix(line, "@@") {
+				headers = append(headers, line)
+			}
+		}
+		if !strings.HasPrefix(diff, "--- a/a.go\n+++ b/a.go\n") || !reflect.DeepEqual(headers, test.headers) {
+			t.Errorf("%d lines, changed %v: got headers %v, expected %v", test.length, test.changed, headers, test.headers)
+		}
+		if marker := strings.Count(diff, "\\ No newline at end of file"); (marker == 2) == test.newline {
+			t.Errorf("%d lines, changed %v: got %d missing newline markers", test.length, test.changed, marker)
+		}
+	}
+}
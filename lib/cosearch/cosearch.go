@@ -7,6 +7,9 @@
 package cosearch
 
 import (
+  "fmt"
+  "os"
+  "path/filepath"
   "strconv"
   "regexp"
   "strings"
@@ -17,107 +20,479 @@ import (
 	coutils "codis/lib/coutils"
 )
 
+// globals
+
+var ContextBefore = 2
+var ContextAfter = 2
+var CropWidth = 75
+var kindPattern = regexp.MustCompile(`(^|\s)kind:(\S*)`)
+
+/* 
+** @name: SetContext 
+** @description: Sets the number of context lines before and after each result. 
+*/
+func SetContext(lines int) {
+  ContextBefore, ContextAfter = lines, lines
+}
+
+/* 
+** @name: contextRange 
+** @description: Returns the first and last index of the lines around a result (without crossing into another file). 
+*/
+func contextRange(index int, orderedKeys []cotypes.RowLabel) (int, int) {
+  filePath := orderedKeys[index].FilePath
+  start, end := index, index
+  for start > 0 && index - start < ContextBefore && orderedKeys[start-1].FilePath == filePath {
+    start -= 1
+  }
+  for end < len(orderedKeys) - 1 && end - index < ContextAfter && orderedKeys[end+1].FilePath == filePath {
+    end += 1
+  }
+  return start, end
+}
+
 /* 
 ** @name: formatResult 
 ** @description: Takes the result (index) and returns a string that shows the lines around it.
 */
 func formatResult(index int, labeledRows map[cotypes.RowLabel]string, orderedKeys []cotypes.RowLabel) string {
   result := "\n\n\n"
-  for i := index-2; i <= index+2; i++ {
-    if i >= 0 && i < len(orderedKeys) { 
-      if i == index {
-        result += strconv.Itoa(i) + ">  " + coutils.CropString(labeledRows[orderedKeys[i]], 75, "\n")
-      } else {
-    result += strconv.Itoa(i) + "|  " + coutils.CropString(labeledRows[orderedKeys[i]],75, "\n")
-      }
-    } 
+  start, end := contextRange(index, orderedKeys)
+  for i := start; i <= end; i++ {
+    linenumber := strconv.Itoa(orderedKeys[i].Linenumber)
+    if i == index {
+      result += linenumber + ">  " + coutils.CropString(labeledRows[orderedKeys[i]], CropWidth, "\n")
+    } else {
+      result += linenumber + "|  " + coutils.CropString(labeledRows[orderedKeys[i]], CropWidth, "\n")
+    }
   } 
   return result
 }
 
 /* 
 ** @name: computeFuzzyScore 
-** @description: Computes the highest "fuzzy" score on a line of code given a query. 
+** @description: Computes the highest "fuzzy" score on a line of code given a query (and the bytes of the matched characters). 
 ** @note: Add a boolean function to shorten the if-statements.
 */
-func computeFuzzyScore(line string, query string) int { 
+func computeFuzzyScore(line string, query string) (int, [][2]int) { 
   score, tempScore := 0, 0
   prevIndex, queryIndex := 0, 0
+  matches, offset := [][2]int{}, 0
   for index, character := range strings.SplitAfter(line,"") {
     if len(query) - 1 > queryIndex {
       if strings.ToLower(string(query[queryIndex])) == strings.ToLower(character) && math.Abs(float64(prevIndex)-float64(index)) <= 1 {
         score += 1
         queryIndex += 1
         prevIndex = index
+        matches = append(matches, [2]int{offset, offset + len(character)})
       } else if len(query) - 2 > queryIndex && strings.ToLower(string(query[queryIndex + 1])) == strings.ToLower(character) {
         score += 1
         queryIndex += 2
         prevIndex = index
+        matches = append(matches, [2]int{offset, offset + len(character)})
       } else if math.Abs(float64(prevIndex)-float64(index)) >= 2 && strings.ToLower(string(query[0])) == strings.ToLower(character) {
         queryIndex = 1
         score = 1
         prevIndex = index
+        matches = [][2]int{{offset, offset + len(character)}}
         if tempScore > score { score = tempScore }
       }
     }
+    offset += len(character)
   }
   if tempScore > score { score = tempScore }
-  return score
+  return score, matches
 }
 
 /* 
-** @name: BasicQuery 
-** @description: Returns lines that contain a subquery. 
+** @name: inContext 
+** @description: Returns true if a row matches the selected categories and comment setting. 
 */
-func BasicQuery(query string, contextCategories []string, contextComment bool) ([]string, []string) {
-  coparse.QueryCounts = coparse.ReturnEmptyQueryResults()
-  var reQuery, err = regexp.Compile(query)
-  results, locations := []string{}, []string{}
+func inContext(key cotypes.RowLabel, contextCategories []string, contextComment bool) bool {
+  return (len(contextCategories) == 0 || coutils.ContainsString(contextCategories, key.Category)) && !(key.HasComment && !contextComment)
+}
+
+/* 
+** @name: rowLabels 
+** @description: Returns the names of the labels that are set on a row. 
+*/
+func rowLabels(key cotypes.RowLabel) []string {
+  labels := []string{}
+  if key.HasFunction { labels = append(labels, "function") }
+  if key.HasObject { labels = append(labels, "object") }
+  if key.HasVariableDeclaration { labels = append(labels, "variable") }
+  if key.HasDomain { labels = append(labels, "domain") }
+  if key.HasComment { labels = append(labels, "comment") }
+  if key.ImportedCode != "" { labels = append(labels, "import") }
+  for index, label := range coparse.CustomLabels {
+    if key.CustomLabels & (1 << index) != 0 { labels = append(labels, label.Name) }
+  }
+  return labels
+}
+
+/* 
+** @name: ParseKinds 
+** @description: Removes the kind: filters from a query. Each filter is a comma separated list of labels of which a row needs at least one. 
+*/
+func ParseKinds(query string) (string, [][]string, error) {
+  kinds := [][]string{}
+  for _, match := range kindPattern.FindAllStringSubmatch(query, -1) {
+    alternatives := []string{}
+    for _, kind := range strings.Split(match[2], ",") {
+      if kind == "" {
+        continue
+      } else if !coutils.ContainsString(coparse.LabelNames(), kind) {
+        return query, kinds, fmt.Errorf("unknown kind %q (%s)", kind, strings.Join(coparse.LabelNames(), ", "))
+      }
+      alternatives = append(alternatives, kind)
+    }
+    if len(alternatives) > 0 {
+      kinds = append(kinds, alternatives)
+    }
+  }
+  return strings.TrimSpace(kindPattern.ReplaceAllString(query, "$1")), kinds, nil
+}
+
+/* 
+** @name: hasKinds 
+** @description: Returns true if a row has at least one label of every kind: filter. 
+*/
+func hasKinds(key cotypes.RowLabel, kinds [][]string) bool {
+  for _, alternatives := range kinds {
+    found := false
+    for _, kind := range alternatives {
+      if coparse.HasLabel(key, kind) {
+        found = true
+        break
+      }
+    }
+    if !found {
+      return false
+    }
+  }
+  return true
+}
+
+/* 
+** @name: NewResult 
+** @description: Creates a structured result (with context lines) for a row in the index (matches are 0-based byte spans). 
+*/
+func NewResult(index int, score int, matches [][2]int) cotypes.Result {
+  key := coparse.OrderedKeys[index]
+  path, err := filepath.Rel(coparse.CurrentDirectory, key.FilePath)
   if err != nil {
-    return []string{"invalid query"}, []string{"None"}
-  }
-	for index, key := range coparse.OrderedKeys {
-	  if (len(contextCategories) == 0 || coutils.ContainsString(contextCategories, key.Category)) && !(key.HasComment && !contextComment) {
-	    if reQuery.MatchString(coparse.LabeledRows[key]) {
-	      results = append(results, formatResult(index, coparse.LabeledRows, coparse.OrderedKeys))
-	      locations = append(locations, key.Filename + ", line " + strconv.Itoa(key.Linenumber))
-	      coparse.QueryCounts[key.FilePath] += 1
-	    }
-	  }
-	}
-	if len(results) == 0 {
-	  return []string{"None"}, []string{"None"}
-	} else {
-	  return results, locations
-	}
+    path = key.FilePath
+  }
+  result := cotypes.Result{
+    Path: path,
+    Line: key.Linenumber,
+    ColumnStart: 1,
+    ColumnEnd: 1,
+    Matches: [][2]int{},
+    Score: score,
+    Labels: rowLabels(key),
+    Text: coparse.LabeledRows[key],
+    ContextBefore: []string{},
+    ContextAfter: []string{},
+    Index: index,
+  }
+  for _, match := range matches {
+    result.Matches = append(result.Matches, [2]int{match[0] + 1, match[1] + 1})
+  }
+  if len(matches) > 0 {
+    result.ColumnStart, result.ColumnEnd = matches[0][0] + 1, matches[0][1] + 1
+  }
+  start, end := contextRange(index, coparse.OrderedKeys)
+  for i := start; i <= end; i++ {
+    if i < index {
+      result.ContextBefore = append(result.ContextBefore, coparse.LabeledRows[coparse.OrderedKeys[i]])
+    } else if i > index {
+      result.ContextAfter = append(result.ContextAfter, coparse.LabeledRows[coparse.OrderedKeys[i]])
+    }
+  }
+  return result
 }
 
 /* 
-** @name: FuzzyQuery
-** @description: Returns the top (20) lines with the highest fuzzy scores.  
+** @name: regexMatches 
+** @description: Returns the spans of the submatches (or of the full matches if the regex has no groups). 
 */
-func FuzzyQuery(query string, contextCategories []string, contextComment bool) ([]string, []string) {
-  coparse.QueryCounts = coparse.ReturnEmptyQueryResults()
-  results, locations := []string{}, []string{}
-  fuzzyResults := []cotypes.RowLabel{}
+func regexMatches(spans [][]int) [][2]int {
+  matches := [][2]int{}
+  for _, span := range spans {
+    if len(span) == 2 {
+      matches = append(matches, [2]int{span[0], span[1]})
+    }
+    for group := 2; group+1 < len(span); group += 2 {
+      if span[group] >= 0 && span[group+1] > span[group] {
+        matches = append(matches, [2]int{span[group], span[group+1]})
+      }
+    }
+  }
+  return matches
+}
+
+/* 
+** @name: BasicSearch 
+** @description: Streams the rows that match a regular expression. Stops when emit returns false. 
+*/
+func BasicSearch(query string, contextCategories []string, contextComment bool, emit func(cotypes.Result) bool) error {
+  query, kinds, err := ParseKinds(query)
+  if err != nil {
+    return err
+  }
+  reQuery, err := regexp.Compile(query)
+  if err != nil {
+    return err
+  }
+  for index, key := range coparse.OrderedKeys {
+    if inContext(key, contextCategories, contextComment) && hasKinds(key, kinds) {
+      if spans := reQuery.FindAllStringSubmatchIndex(coparse.LabeledRows[key], -1); spans != nil {
+        if !emit(NewResult(index, 1, regexMatches(spans))) {
+          return nil
+        }
+      }
+    }
+  }
+  return nil
+}
+
+/* 
+** @name: FuzzySearch 
+** @description: Streams the rows with a fuzzy score above the threshold (all rows of the kind: filters if the rest of the query is empty). Stops when emit returns false. 
+*/
+func FuzzySearch(query string, contextCategories []string, contextComment bool, emit func(cotypes.Result) bool) error {
+  query, kinds, err := ParseKinds(query)
+  if err != nil {
+    return err
+  }
   threshold := int(float64(len(query))/2.0)
-  for _, key := range coparse.OrderedKeys {
-	  if (len(contextCategories) == 0 || coutils.ContainsString(contextCategories, key.Category)) && !(key.HasComment && !contextComment) {
-      if computeFuzzyScore(coparse.LabeledRows[key], query) > threshold { 
-	      coparse.QueryCounts[key.FilePath] += 1
-        fuzzyResults = append(fuzzyResults, key)
+  if query == "" && len(kinds) > 0 {
+    threshold = -1
+  }
+  for index, key := range coparse.OrderedKeys {
+    if inContext(key, contextCategories, contextComment) && hasKinds(key, kinds) {
+      if score, matches := computeFuzzyScore(coparse.LabeledRows[key], query); score > threshold {
+        if !emit(NewResult(index, score, matches)) {
+          return nil
+        }
       }
     }
   }
-  if len(fuzzyResults) == 0 {
-	  return []string{"None"}, []string{"None"}
+  return nil
+}
+
+/* 
+** @name: RefineSearch 
+** @description: Streams the results of a previous search whose line also matches a regular expression (or a fuzzy query). Stops when emit returns false. 
+*/
+func RefineSearch(query string, fuzzy bool, results []cotypes.Result, emit func(cotypes.Result) bool) error {
+  query, kinds, err := ParseKinds(query)
+  if err != nil {
+    return err
+  }
+  reQuery, err := regexp.Compile(query)
+  if err != nil && !fuzzy {
+    return err
+  }
+  threshold := int(float64(len(query))/2.0)
+  if query == "" {
+    threshold = -1
+  }
+  for _, result := range results {
+    if result.Index < 0 || result.Index >= len(coparse.OrderedKeys) || !hasKinds(coparse.OrderedKeys[result.Index], kinds) {
+      continue
+    }
+    line := coparse.LabeledRows[coparse.OrderedKeys[result.Index]]
+    if fuzzy {
+      if score, matches := computeFuzzyScore(line, query); score > threshold && !emit(NewResult(result.Index, score, matches)) {
+        return nil
+      }
+    } else if spans := reQuery.FindAllStringSubmatchIndex(line, -1); spans != nil && !emit(NewResult(result.Index, 1, regexMatches(spans))) {
+      return nil
+    }
+  }
+  return nil
+}
+
+/* 
+** @name: SymbolSearch 
+** @description: Streams the declarations of functions/objects whose name contains the query. Stops when emit returns false. 
+*/
+func SymbolSearch(query string, emit func(cotypes.Result) bool) {
+  for _, symbol := range coparse.Symbols {
+    if strings.Contains(strings.ToLower(symbol.Name), strings.ToLower(query)) {
+      column := strings.Index(coparse.LabeledRows[coparse.OrderedKeys[symbol.Index]], symbol.Name)
+      if column < 0 { column = 0 }
+      if !emit(NewResult(symbol.Index, len(query), [][2]int{{column, column + len(symbol.Name)}})) {
+        return
+      }
+    }
+  }
+}
+
+/* 
+** @name: ListEntry 
+** @description: Returns the location, the (trimmed) line and its matches (0-based) of a result for the result list. 
+*/
+func ListEntry(result cotypes.Result) (string, string, [][2]int) {
+  text := strings.TrimLeft(result.Text, " \t")
+  trimmed := len(result.Text) - len(text)
+  matches := [][2]int{}
+  for _, match := range result.Matches {
+    matches = append(matches, [2]int{match[0] - 1 - trimmed, match[1] - 1 - trimmed})
+  }
+  return filepath.Base(result.Path) + ":" + strconv.Itoa(result.Line) + "  ", text, matches
+}
+
+/* 
+** @name: PreviewLines 
+** @description: Returns the numbered lines around a result (within the same file) and which of them is the result. 
+*/
+func PreviewLines(result cotypes.Result, height int) ([]string, []string, int) {
+  prefixes, lines, hitRow := []string{}, []string{}, 0
+  filePath := coparse.OrderedKeys[result.Index].FilePath
+  start := result.Index - height/2
+  if start < 0 { start = 0 }
+  for start < result.Index && coparse.OrderedKeys[start].FilePath != filePath {
+    start += 1
+  }
+  for i := start; i < len(coparse.OrderedKeys) && len(lines) < height; i++ {
+    key := coparse.OrderedKeys[i]
+    if key.FilePath != filePath {
+      break
+    }
+    marker := "|  "
+    if i == result.Index {
+      marker, hitRow = ">  ", len(lines)
+    }
+    prefixes = append(prefixes, strconv.Itoa(key.Linenumber) + coutils.ResponsiveTab(strconv.Itoa(key.Linenumber)) + marker)
+    lines = append(lines, coparse.LabeledRows[key])
+  }
+  return prefixes, lines, hitRow
+}
+
+/* 
+** @name: ZeroBasedMatches 
+** @description: Converts the (1-based) matches of a result to 0-based byte spans. 
+*/
+func ZeroBasedMatches(result cotypes.Result) [][2]int {
+  matches := [][2]int{}
+  for _, match := range result.Matches {
+    matches = append(matches, [2]int{match[0] - 1, match[1] - 1})
+  }
+  return matches
+}
+
+/* 
+** @name: FormatLine 
+** @description: Formats a result as one line for grep -n, vim's quickfix list or emacs' compilation mode (paths are relative to the working directory). 
+*/
+func FormatLine(result cotypes.Result, format string) string {
+  path := filepath.Join(coparse.CurrentDirectory, result.Path)
+  if workingDirectory, err := os.Getwd(); err == nil {
+    if relativePath, err := filepath.Rel(workingDirectory, path); err == nil {
+      path = relativePath
+    }
   }
-  for _, key := range fuzzyResults {
-    index := coutils.FindIndex(coparse.OrderedKeys, key)
-    results = append(results, formatResult(index, coparse.LabeledRows, coparse.OrderedKeys))
+  text := strings.TrimRight(result.Text, "\r")
+  line := strconv.Itoa(result.Line)
+  if format == "grep" {
+    return path + ":" + line + ":" + text
+  } else if format == "vimgrep" {
+    return path + ":" + line + ":" + strconv.Itoa(result.ColumnStart) + ":" + text
+  }
+  return path + ":" + line + "." + strconv.Itoa(result.ColumnStart) + "-" + strconv.Itoa(result.ColumnEnd) + ": " + text
+}
+
+/* 
+** @name: GroupResults 
+** @description: Groups results by file and merges hits whose context lines touch into one snippet (hits are indexes in results). 
+*/
+func GroupResults(results []cotypes.Result) []cotypes.FileGroup {
+  groups := []cotypes.FileGroup{}
+  positions := make(map[string]int)
+  for hit, result := range results {
+    position, ok := positions[result.Path]
+    if !ok {
+      position = len(groups)
+      positions[result.Path] = position
+      groups = append(groups, cotypes.FileGroup{Path: result.Path, Hits: []int{}, Snippets: []cotypes.Snippet{}})
+    }
+    group := &groups[position]
+    group.Hits = append(group.Hits, hit)
+    start, end := contextRange(result.Index, coparse.OrderedKeys)
+    if last := len(group.Snippets) - 1; last >= 0 && start <= group.Snippets[last].End + 1 && result.Index > group.Snippets[last].Start {
+      if end > group.Snippets[last].End {
+        group.Snippets[last].End = end
+      }
+      group.Snippets[last].Hits = append(group.Snippets[last].Hits, hit)
+    } else {
+      group.Snippets = append(group.Snippets, cotypes.Snippet{Start: start, End: end, Hits: []int{hit}})
+    }
+  }
+  return groups
+}
+
+/* 
+** @name: AppendResults 
+** @description: Appends the display text and locations of (streamed) results and updates the query counts. 
+*/
+func AppendResults(results []string, locations []string, structuredResults []cotypes.Result) ([]string, []string) {
+  for _, result := range structuredResults {
+    key := coparse.OrderedKeys[result.Index]
+    results = append(results, formatResult(result.Index, coparse.LabeledRows, coparse.OrderedKeys))
     locations = append(locations, key.Filename + ", line " + strconv.Itoa(key.Linenumber))
-  } 
-  return results, locations 
+    coparse.QueryCounts[key.FilePath] += 1
+  }
+  return results, locations
+}
+
+/* 
+** @name: FormatResults 
+** @description: Turns structured results into display text and locations (and resets the query counts). 
+*/
+func FormatResults(structuredResults []cotypes.Result) ([]string, []string) {
+  coparse.QueryCounts = coparse.ReturnEmptyQueryResults()
+  results, locations := AppendResults([]string{}, []string{}, structuredResults)
+  if len(results) == 0 {
+    return []string{"None"}, []string{"None"}
+  }
+  return results, locations
+}
+
+/* 
+** @name: BasicQuery 
+** @description: Returns lines that contain a subquery. 
+*/
+func BasicQuery(query string, contextCategories []string, contextComment bool) ([]string, []string) {
+  structuredResults := []cotypes.Result{}
+  err := BasicSearch(query, contextCategories, contextComment, func(result cotypes.Result) bool {
+    structuredResults = append(structuredResults, result)
+    return true
+  })
+  if err != nil {
+    coparse.QueryCounts = coparse.ReturnEmptyQueryResults()
+    return []string{"invalid query: " + err.Error()}, []string{"None"}
+  }
+  return FormatResults(structuredResults)
+}
+
+/* 
+** @name: FuzzyQuery
+** @description: Returns the lines with a fuzzy score above the threshold.  
+*/
+func FuzzyQuery(query string, contextCategories []string, contextComment bool) ([]string, []string) {
+  structuredResults := []cotypes.Result{}
+  err := FuzzySearch(query, contextCategories, contextComment, func(result cotypes.Result) bool {
+    structuredResults = append(structuredResults, result)
+    return true
+  })
+  if err != nil {
+    coparse.QueryCounts = coparse.ReturnEmptyQueryResults()
+    return []string{"invalid query: " + err.Error()}, []string{"None"}
+  }
+  return FormatResults(structuredResults)
 }
 
 /* not for this version
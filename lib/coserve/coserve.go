@@ -0,0 +1,140 @@
+/* 
+** @name: coserve
+** @author: Timo Kats
+** @description: Serves the (in memory) index as a local HTTP/JSON API.
+*/
+
+package coserve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	engine "codis/engine"
+	coexplore "codis/lib/coexplore"
+)
+
+/* 
+** @name: writeJSON
+** @description: Writes a value as a JSON response.
+*/
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+/* 
+** @name: writeResponse
+** @description: Writes a value as a JSON response, or the error if there is one (nothing if the request is cancelled).
+*/
+func writeResponse(w http.ResponseWriter, r *http.Request, value interface{}, err error) {
+	if r.Context().Err() != nil {
+		return
+	} else if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	} else {
+		writeJSON(w, http.StatusOK, value)
+	}
+}
+
+/* 
+** @name: queryFilter
+** @description: Returns the categories and comment setting of a request (these mirror the settings form).
+*/
+func queryFilter(r *http.Request) engine.Filter {
+	categories := []string{}
+	for _, category := range strings.Split(r.URL.Query().Get("categories"), ",") {
+		if category != "" {
+			categories = append(categories, category)
+		}
+	}
+	return engine.Filter{Categories: categories, ExcludeComments: r.URL.Query().Get("comments") == "false"}
+}
+
+/* 
+** @name: collect
+** @description: Returns an emit function that collects results until the limit.
+*/
+func collect(r *http.Request, results *[]engine.Result) func(engine.Result) bool {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	return func(result engine.Result) bool {
+		*results = append(*results, result)
+		return limit <= 0 || len(*results) < limit
+	}
+}
+
+/* 
+** @name: NewHandler
+** @description: Returns the routes of the API for an engine.
+*/
+func NewHandler(e *engine.Engine) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		results := []engine.Result{}
+		err := e.SearchFunc(r.Context(), r.URL.Query().Get("q"), queryFilter(r), collect(r, &results))
+		writeResponse(w, r, results, err)
+	})
+	mux.HandleFunc("/fuzzy", func(w http.ResponseWriter, r *http.Request) {
+		results := []engine.Result{}
+		err := e.FuzzyFunc(r.Context(), r.URL.Query().Get("q"), queryFilter(r), collect(r, &results))
+		writeResponse(w, r, results, err)
+	})
+	mux.HandleFunc("/structural", func(w http.ResponseWriter, r *http.Request) {
+		results := []engine.Result{}
+		err := e.StructuralFunc(r.Context(), r.URL.Query().Get("q"), queryFilter(r), collect(r, &results))
+		writeResponse(w, r, results, err)
+	})
+	mux.HandleFunc("/similar", func(w http.ResponseWriter, r *http.Request) {
+		results := []engine.Result{}
+		err := e.SimilarFunc(r.Context(), r.URL.Query().Get("q"), queryFilter(r), collect(r, &results))
+		writeResponse(w, r, results, err)
+	})
+	mux.HandleFunc("/symbols", func(w http.ResponseWriter, r *http.Request) {
+		symbols, err := e.Symbols(r.Context(), r.URL.Query().Get("q"))
+		writeResponse(w, r, symbols, err)
+	})
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		overviews, err := e.FileOverview(r.Context(), r.URL.Query().Get("q"), queryFilter(r))
+		writeResponse(w, r, overviews, err)
+	})
+	mux.HandleFunc("/tree", func(w http.ResponseWriter, r *http.Request) {
+		maxLevel, err := strconv.Atoi(r.URL.Query().Get("depth"))
+		if err != nil {
+			maxLevel = coexplore.MaxDepth
+		}
+		tree, err := e.Tree(r.Context(), maxLevel)
+		writeResponse(w, r, tree, err)
+	})
+	mux.HandleFunc("/deps", func(w http.ResponseWriter, r *http.Request) {
+		dependencies, err := e.Dependencies(r.Context())
+		writeResponse(w, r, dependencies, err)
+	})
+	mux.HandleFunc("/reindex", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST to reindex"})
+			return
+		}
+		if err := e.Reindex(); errors.Is(err, engine.ErrStale) {
+			writeResponse(w, r, nil, err)
+			return
+		}
+		stats, err := e.Stats()
+		writeResponse(w, r, stats, err)
+	})
+	return mux
+}
+
+/* 
+** @name: Serve
+** @description: Starts the HTTP server for an engine on an address.
+*/
+func Serve(e *engine.Engine, addr string) error {
+	fmt.Fprintln(os.Stderr, "codis: serving " + e.Root + " on http://" + addr)
+	return http.ListenAndServe(addr, NewHandler(e))
+}
@@ -0,0 +1,380 @@
+/* 
+** @name: cosettings
+** @author: Timo Kats
+** @description: Settings that can be changed at runtime (:set) or in a config file (~/.config/codis/config.toml and .codis.toml).
+*/
+
+package cosettings
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/alecthomas/chroma/styles"
+
+	coparse "codis/lib/coparse"
+	cosearch "codis/lib/cosearch"
+	coexplore "codis/lib/coexplore"
+	coview "codis/lib/coview"
+	coeditor "codis/lib/coeditor"
+	cohistory "codis/lib/cohistory"
+	codependencies "codis/lib/codependencies"
+)
+
+// globals
+
+var BoxWidth = 0
+var CropWidth = 0
+var ProjectFile = ".codis.toml"
+var Registry []Setting
+var BuiltinCategories []string
+var GeneralKeys = []string{"context"} // settings that other settings refine (context sets before and after)
+
+// structs
+
+type Setting struct {
+	Key string
+	Kind string // int, string or list
+	Description string
+	Get func() string
+	Set func(value string) error
+}
+
+/* 
+** @name: intSetting
+** @description: Returns a setting for a number with a minimum value.
+*/
+func intSetting(key string, description string, minimum int, value *int) Setting {
+	return Setting{key, "int", description, func() string { return strconv.Itoa(*value) }, func(input string) error {
+		number, err := strconv.Atoi(input)
+		if err != nil || number < minimum {
+			return fmt.Errorf("%s should be a number >= %d, not %q", key, minimum, input)
+		}
+		*value = number
+		return nil
+	}}
+}
+
+/* 
+** @name: setCrop
+** @description: Sets the crop width of result lines (0 fits the lines to the terminal).
+*/
+func setCrop(input string) error {
+	number, err := strconv.Atoi(input)
+	if err != nil || number < 0 {
+		return fmt.Errorf("crop should be a number >= 0 (0 fits the terminal), not %q", input)
+	}
+	CropWidth = number
+	if number > 0 {
+		cosearch.CropWidth = number
+	}
+	return nil
+}
+
+/* 
+** @name: setWidth
+** @description: Sets the width of the result box (0 fits the box to the terminal).
+*/
+func setWidth(input string) error {
+	number, err := strconv.Atoi(input)
+	if err != nil || (number != 0 && number < 40) {
+		return fmt.Errorf("width should be 0 (fits the terminal) or a number >= 40, not %q", input)
+	}
+	BoxWidth = number
+	return nil
+}
+
+/* 
+** @name: setEntries
+** @description: Sets the filename globs of the entry points that the orphan view doesn't list (comma separated, e.g. main.*,cmd_*.go).
+*/
+func setEntries(input string) error {
+	globs := []string{}
+	for _, glob := range strings.Split(input, ",") {
+		if glob = strings.TrimSpace(glob); glob == "" {
+			continue
+		} else if _, err := filepath.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid entry glob %q", glob)
+		}
+		globs = append(globs, glob)
+	}
+	codependencies.EntryGlobs = globs
+	return nil
+}
+
+/* 
+** @name: setTheme
+** @description: Sets the syntax highlighting theme of the file viewer.
+*/
+func setTheme(input string) error {
+	for _, name := range styles.Names() {
+		if name == input {
+			coview.Theme = input
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown theme %q (e.g. monokai, dracula, github, nord, solarized-dark)", input)
+}
+
+func init() {
+	for category := range coparse.FileCategories {
+		BuiltinCategories = append(BuiltinCategories, category)
+	}
+	Registry = []Setting{
+		{"context", "int", "lines of context before and after each result", func() string {
+			return strconv.Itoa(cosearch.ContextBefore)
+		}, func(input string) error {
+			lines, err := strconv.Atoi(input)
+			if err != nil || lines < 0 {
+				return fmt.Errorf("context should be a number >= 0, not %q", input)
+			}
+			cosearch.SetContext(lines)
+			return nil
+		}},
+		intSetting("before", "lines of context before each result", 0, &cosearch.ContextBefore),
+		intSetting("after", "lines of context after each result", 0, &cosearch.ContextAfter),
+		intSetting("depth", "max depth of the file tree in the explorer", 1, &coexplore.MaxDepth),
+		intSetting("page", "lines per page in the explorer, dependency and orphan views", 1, &coparse.PageSize),
+		intSetting("history", "number of searches kept in the history of a project", 0, &cohistory.MaxEntries),
+		{"crop", "int", "max characters per result line (0 fits the terminal)", func() string { return strconv.Itoa(CropWidth) }, setCrop},
+		{"width", "int", "width of the result box (0 fits the terminal)", func() string { return strconv.Itoa(BoxWidth) }, setWidth},
+		{"entries", "list", "filename globs of entry points, which are never orphans", func() string {
+			return strings.Join(codependencies.EntryGlobs, ",")
+		}, setEntries},
+		{"theme", "string", "syntax highlighting theme of the file viewer", func() string { return coview.Theme }, setTheme},
+		{"editor", "string", "arguments for $EDITOR, e.g. +{line} {file} (empty picks one for the editor)", func() string {
+			return coeditor.Template
+		}, func(input string) error {
+			coeditor.Template = input
+			return nil
+		}},
+	}
+}
+
+/* 
+** @name: Keys
+** @description: Returns the keys of all settings (one categories.<name> key per file category and one labels.<name> key per custom label).
+*/
+func Keys() []string {
+	keys := []string{}
+	for _, setting := range Registry {
+		keys = append(keys, setting.Key)
+	}
+	categories := []string{}
+	for category := range coparse.FileCategories {
+		categories = append(categories, "categories." + category)
+	}
+	for _, label := range coparse.CustomLabels {
+		categories = append(categories, "labels." + label.Name)
+	}
+	sort.Strings(categories)
+	return append(keys, categories...)
+}
+
+/* 
+** @name: Get
+** @description: Returns the current value of a setting.
+*/
+func Get(key string) (string, error) {
+	if category, ok := strings.CutPrefix(key, "categories."); ok {
+		category = strings.TrimSuffix(category, "!")
+		if extensions, ok := coparse.FileCategories[category]; ok {
+			return strings.Join(extensions, ","), nil
+		}
+		return "", fmt.Errorf("unknown category %q", category)
+	}
+	if name, ok := strings.CutPrefix(key, "labels."); ok {
+		for _, label := range coparse.CustomLabels {
+			if label.Name == name {
+				return label.Pattern.String(), nil
+			}
+		}
+		return "", fmt.Errorf("unknown label %q", name)
+	}
+	for _, setting := range Registry {
+		if setting.Key == key {
+			return setting.Get(), nil
+		}
+	}
+	return "", fmt.Errorf("unknown setting %q (type set to list them)", key)
+}
+
+/* 
+** @name: setCategory
+** @description: Sets the extensions and filenames of a file category (each can only be in one category). They are added to a built-in category unless replace is set, and an empty list removes a custom category (or a built-in one with replace).
+*/
+func setCategory(category string, input string, replace bool) error {
+	if category == "" || strings.ContainsAny(category, " .") {
+		return fmt.Errorf("invalid category name %q", category)
+	}
+	extensions := []string{}
+	for _, extension := range strings.Split(input, ",") {
+		if extension = strings.TrimPrefix(strings.TrimSpace(extension), "."); extension != "" {
+			extensions = append(extensions, extension)
+		}
+	}
+	if !replace && containsString(BuiltinCategories, category) {
+		merged := append([]string{}, coparse.FileCategories[category]...)
+		for _, extension := range extensions {
+			if !containsString(merged, extension) {
+				merged = append(merged, extension)
+			}
+		}
+		extensions = merged
+	}
+	for other, otherExtensions := range coparse.FileCategories {
+		remaining := []string{}
+		for _, extension := range otherExtensions {
+			if !containsString(extensions, extension) {
+				remaining = append(remaining, extension)
+			}
+		}
+		coparse.FileCategories[other] = remaining
+		if len(remaining) == 0 {
+			delete(coparse.FileCategories, other)
+		}
+	}
+	if len(extensions) == 0 {
+		delete(coparse.FileCategories, category)
+	} else {
+		coparse.FileCategories[category] = extensions
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, current := range values {
+		if current == value {
+			return true
+		}
+	}
+	return false
+}
+
+/* 
+** @name: Set
+** @description: Validates and changes a setting (categories.<name>! replaces the list of a built-in category instead of adding to it).
+*/
+func Set(key string, value string) error {
+	if category, ok := strings.CutPrefix(key, "categories."); ok {
+		category, replace := strings.CutSuffix(category, "!")
+		return setCategory(category, value, replace)
+	}
+	if name, ok := strings.CutPrefix(key, "labels."); ok {
+		return coparse.SetCustomLabel(name, value)
+	}
+	for _, setting := range Registry {
+		if setting.Key == key {
+			return setting.Set(strings.TrimSpace(value))
+		}
+	}
+	return fmt.Errorf("unknown setting %q (type set to list them)", key)
+}
+
+/* 
+** @name: List
+** @description: Returns every setting with its value and description.
+*/
+func List() string {
+	list := ""
+	for _, key := range Keys() {
+		value, _ := Get(key)
+		description := "file extensions and names of the category"
+		if strings.HasPrefix(key, "labels.") {
+			description = "regular expression of the label (usable as kind:)"
+		}
+		for _, setting := range Registry {
+			if setting.Key == key {
+				description = setting.Description
+			}
+		}
+		list += fmt.Sprintf("    %-22s %-20s %s\n", key, value, description)
+	}
+	return list
+}
+
+/* 
+** @name: flatten
+** @description: Turns a (nested) toml table into setting keys and values (tables become key prefixes).
+*/
+func flatten(prefix string, table map[string]interface{}, values map[string]string) {
+	for key, value := range table {
+		switch typed := value.(type) {
+			case map[string]interface{}:
+				flatten(prefix + key + ".", typed, values)
+			case []interface{}:
+				items := []string{}
+				for _, item := range typed {
+					items = append(items, fmt.Sprint(item))
+				}
+				values[prefix + key] = strings.Join(items, ",")
+			default:
+				values[prefix + key] = fmt.Sprint(typed)
+		}
+	}
+}
+
+/* 
+** @name: Load
+** @description: Applies the settings in a config file (a missing file is not an error). General keys (context) are applied before the others, so before and after override context.
+*/
+func Load(path string) []error {
+	table := make(map[string]interface{})
+	if _, err := toml.DecodeFile(path, &table); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return []error{fmt.Errorf("%s: %v", path, err)}
+	}
+	values := make(map[string]string)
+	flatten("", table, values)
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.SliceStable(keys, func(i, j int) bool { // general keys first, so the specific ones override them
+		if general := containsString(GeneralKeys, keys[i]); general != containsString(GeneralKeys, keys[j]) {
+			return general
+		}
+		return keys[i] < keys[j]
+	})
+	errs := []error{}
+	for _, key := range keys {
+		if err := Set(key, values[key]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", path, err))
+		}
+	}
+	return errs
+}
+
+/* 
+** @name: UserConfig
+** @description: Returns the path of the user config ($XDG_CONFIG_HOME/codis/config.toml or ~/.config/codis/config.toml).
+*/
+func UserConfig() string {
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		return filepath.Join(configHome, "codis", "config.toml")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "codis", "config.toml")
+}
+
+/* 
+** @name: LoadConfig
+** @description: Applies the user config and then the config of the project (which overrides it).
+*/
+func LoadConfig(projectDirectory string) []error {
+	errs := []error{}
+	if userConfig := UserConfig(); userConfig != "" {
+		errs = append(errs, Load(userConfig)...)
+	}
+	return append(errs, Load(filepath.Join(projectDirectory, ProjectFile))...)
+}
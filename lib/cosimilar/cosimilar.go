@@ -0,0 +1,264 @@
+/* 
+** @name: cosimilar
+** @author: Timo Kats
+** @description: Finds code that is similar to a snippet or to the code around a line (e.g. copy-pasted logic with other names).
+** @note: The code is split into regions (functions or blocks), every region is a tf-idf vector of shingles of normalized tokens and regions are ranked by their cosine similarity.
+*/
+
+package cosimilar
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	coparse "codis/lib/coparse"
+	cosearch "codis/lib/cosearch"
+	cotypes "codis/lib/cotypes"
+	coutils "codis/lib/coutils"
+)
+
+// globals
+
+var ShingleSize = 4
+var MaxRegionLines = 40
+var MinScore = 0.2
+var MaxResults = 50
+var Categories = []string{"code", "web"}
+var Regions []Region
+var postings map[string][]int
+var documentFrequency map[string]int
+var tokenPattern = regexp.MustCompile(`[A-Za-z_$][\w$]*|\d[\w.]*|"(?:\\.|[^"\\])*"|'(?:\\.|[^'\\])*'|` + "`[^`]*`" + `|\S`)
+var locationPattern = regexp.MustCompile(`^@(.+):(\d+)$`)
+var commentPrefixes = []string{"//", "#", "/*", "*/", "* ", "--", "<!--"}
+var keywords = []string{
+	"if", "else", "for", "while", "do", "switch", "case", "default", "break", "continue", "return", "goto",
+	"func", "function", "def", "fn", "fun", "class", "struct", "interface", "enum", "type", "var", "let", "const",
+	"try", "catch", "except", "finally", "throw", "raise", "defer", "go", "select", "range", "in", "of", "new",
+	"delete", "import", "package", "from", "as", "with", "yield", "async", "await", "lambda", "match", "impl",
+	"nil", "null", "None", "true", "false", "True", "False", "this", "self", "and", "or", "not", "is",
+}
+
+// structs
+
+type Region struct {
+	Start   int // index of the first line in coparse.OrderedKeys
+	End     int // index after the last line
+	Weights map[string]float64 // tf-idf weight per shingle
+	Norm    float64
+}
+
+/* 
+** @name: normalize
+** @description: Returns a token with its names, numbers and strings replaced by a placeholder (keywords and symbols stay).
+*/
+func normalize(token string) string {
+	if coutils.ContainsString(keywords, token) {
+		return token
+	}
+	switch first := token[0]; {
+		case first == '_' || first == '$' || (first >= 'a' && first <= 'z') || (first >= 'A' && first <= 'Z'):
+			return "ID"
+		case first >= '0' && first <= '9':
+			return "NUM"
+		case first == '"' || first == '\'' || first == '`':
+			return "STR"
+	}
+	return token
+}
+
+/* 
+** @name: tokens
+** @description: Returns the normalized tokens of lines of code (comment lines are skipped).
+*/
+func tokens(lines []string) []string {
+	normalized := []string{}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		comment := false
+		for _, prefix := range commentPrefixes {
+			if strings.HasPrefix(trimmed, prefix) || trimmed == "*" {
+				comment = true
+			}
+		}
+		if comment {
+			continue
+		}
+		for _, token := range tokenPattern.FindAllString(trimmed, -1) {
+			normalized = append(normalized, normalize(token))
+		}
+	}
+	return normalized
+}
+
+/* 
+** @name: shingles
+** @description: Counts the sequences of ShingleSize tokens.
+*/
+func shingles(tokens []string) map[string]int {
+	counts := make(map[string]int)
+	for start := 0; start + ShingleSize <= len(tokens); start++ {
+		counts[strings.Join(tokens[start:start+ShingleSize], " ")]++
+	}
+	return counts
+}
+
+/* 
+** @name: weigh
+** @description: Returns the tf-idf weights of counted shingles and the length of the vector.
+*/
+func weigh(counts map[string]int) (map[string]float64, float64) {
+	weights, norm := make(map[string]float64), 0.0
+	for shingle, count := range counts {
+		weight := (1 + math.Log(float64(count))) * math.Log(1 + float64(len(Regions)) / float64(1 + documentFrequency[shingle]))
+		weights[shingle] = weight
+		norm += weight * weight
+	}
+	return weights, math.Sqrt(norm)
+}
+
+/* 
+** @name: regionEnd
+** @description: Returns true if a region that starts at start ends after the line at index (a new file or function, or a blank line in a long region).
+*/
+func regionEnd(start int, index int) bool {
+	if index + 1 >= len(coparse.OrderedKeys) {
+		return true
+	}
+	key, next := coparse.OrderedKeys[index], coparse.OrderedKeys[index+1]
+	length := index + 1 - start
+	blank := strings.TrimSpace(coparse.LabeledRows[key]) == ""
+	return next.FilePath != key.FilePath || next.HasFunction || length >= 2 * MaxRegionLines || (length >= MaxRegionLines && blank)
+}
+
+/* 
+** @name: Build
+** @description: Splits the indexed code into regions and computes their vectors (called after (re)indexing).
+*/
+func Build() {
+	Regions, postings, documentFrequency = []Region{}, make(map[string][]int), make(map[string]int)
+	counts, start := []map[string]int{}, 0
+	for index, key := range coparse.OrderedKeys {
+		if !coutils.ContainsString(Categories, key.Category) {
+			start = index + 1
+			continue
+		}
+		if !regionEnd(start, index) {
+			continue
+		}
+		lines := []string{}
+		for _, regionKey := range coparse.OrderedKeys[start:index+1] {
+			lines = append(lines, coparse.LabeledRows[regionKey])
+		}
+		if regionCounts := shingles(tokens(lines)); len(regionCounts) > 0 {
+			Regions = append(Regions, Region{Start: start, End: index + 1})
+			counts = append(counts, regionCounts)
+			for shingle := range regionCounts {
+				documentFrequency[shingle]++
+				postings[shingle] = append(postings[shingle], len(Regions) - 1)
+			}
+		}
+		start = index + 1
+	}
+	for regionIndex := range Regions {
+		Regions[regionIndex].Weights, Regions[regionIndex].Norm = weigh(counts[regionIndex])
+	}
+}
+
+/* 
+** @name: regionAt
+** @description: Returns the region with a line of a file (the path is absolute or relative to the index), or -1.
+*/
+func regionAt(path string, linenumber int) int {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(coparse.CurrentDirectory, path)
+	}
+	for regionIndex, region := range Regions {
+		for _, key := range coparse.OrderedKeys[region.Start:region.End] {
+			if key.FilePath == path && key.Linenumber == linenumber {
+				return regionIndex
+			}
+		}
+	}
+	return -1
+}
+
+/* 
+** @name: firstLine
+** @description: Returns the index of the first line of a region that isn't blank (where its result is shown).
+*/
+func firstLine(region Region) int {
+	for index := region.Start; index < region.End; index++ {
+		if strings.TrimSpace(coparse.LabeledRows[coparse.OrderedKeys[index]]) != "" {
+			return index
+		}
+	}
+	return region.Start
+}
+
+/* 
+** @name: queryVector
+** @description: Returns the vector of a query (a snippet, or @path:line for the region with that line) and the region to leave out of the results (or -1).
+*/
+func queryVector(query string) (map[string]float64, float64, int, error) {
+	if location := locationPattern.FindStringSubmatch(strings.TrimSpace(query)); location != nil {
+		linenumber, _ := strconv.Atoi(location[2])
+		regionIndex := regionAt(location[1], linenumber)
+		if regionIndex < 0 {
+			return nil, 0, -1, fmt.Errorf("there is no code at %s:%d", location[1], linenumber)
+		}
+		return Regions[regionIndex].Weights, Regions[regionIndex].Norm, regionIndex, nil
+	}
+	counts := shingles(tokens(strings.Split(query, "\n")))
+	if len(counts) == 0 {
+		return nil, 0, -1, errors.New("the snippet is too short, paste at least a few statements")
+	}
+	weights, norm := weigh(counts)
+	return weights, norm, -1, nil
+}
+
+/* 
+** @name: Search
+** @description: Streams the regions that are the most similar to a snippet (or to the region of @path:line), the most similar first. The score is the cosine similarity in percent.
+*/
+func Search(query string, contextCategories []string, emit func(cotypes.Result) bool) error {
+	weights, norm, exclude, err := queryVector(query)
+	if err != nil {
+		return err
+	}
+	products := make(map[int]float64)
+	for shingle, weight := range weights {
+		for _, regionIndex := range postings[shingle] {
+			products[regionIndex] += weight * Regions[regionIndex].Weights[shingle]
+		}
+	}
+	type ranked struct {
+		region int
+		score  float64
+	}
+	ranking := []ranked{}
+	for regionIndex, product := range products {
+		key := coparse.OrderedKeys[Regions[regionIndex].Start]
+		score := product / (norm * Regions[regionIndex].Norm)
+		if regionIndex != exclude && score >= MinScore && (len(contextCategories) == 0 || coutils.ContainsString(contextCategories, key.Category)) {
+			ranking = append(ranking, ranked{regionIndex, score})
+		}
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		if ranking[i].score != ranking[j].score {
+			return ranking[i].score > ranking[j].score
+		}
+		return ranking[i].region < ranking[j].region
+	})
+	for _, result := range ranking[:min(len(ranking), MaxResults)] {
+		if !emit(cosearch.NewResult(firstLine(Regions[result.region]), int(math.Round(result.score * 100)), [][2]int{})) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+/* 
+** @name: cosimilar_test
+** @author: Timo Kats
+** @description: Ranks the regions of a small indexed Go file by their similarity.
+*/
+
+package cosimilar
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	coparse "codis/lib/coparse"
+	cotypes "codis/lib/cotypes"
+)
+
+// globals
+
+var source = `package a
+
+func Sum(values []int) int {
+	total := 0
+	for _, value := range values {
+		if value > 0 {
+			total += value
+		}
+	}
+	return total
+}
+
+func Add(numbers []int) int {
+	result := 0
+	for _, number := range numbers {
+		if number > 0 {
+			result += number
+		}
+	}
+	return result
+}
+
+func Greet(name string) string {
+	message := "hello " + name
+	fmt.Println(message)
+	return strings.ToUpper(message)
+}
+`
+
+/* 
+** @name: index
+** @description: Indexes a temporary project with the source as a.go and builds the regions.
+*/
+func index(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := coparse.Reindex(root); err != nil {
+		t.Fatal(err)
+	}
+	Build()
+}
+
+/* 
+** @name: TestTokens
+** @description: Checks that names, numbers and strings are normalized and comment lines are skipped.
+*/
+func TestTokens(t *testing.T) {
+	tests := []struct {
+		line   string
+		tokens string
+	}{
+		{`total := sum(1, "a")`, `ID : = ID ( NUM , STR )`},
+		{`if err != nil { return err }`, `if ID ! = nil { return ID }`},
+		{`// a comment`, ``},
+		{`for _, value := range values {`, `for ID , ID : = range ID {`},
+	}
+	for _, test := range tests {
+		if got := strings.Join(tokens([]string{test.line}), " "); got != test.tokens {
+			t.Errorf("%q: got %q, expected %q", test.line, got, test.tokens)
+		}
+	}
+}
+
+/* 
+** @name: TestSearch
+** @description: Checks that a renamed copy is the most similar region and that invalid queries are errors.
+*/
+func TestSearch(t *testing.T) {
+	index(t)
+	tests := []struct {
+		query   string
+		lines   []int // the first lines of the results, the most similar first
+		scores  []int
+		invalid bool
+	}{
+		{"@a.go:5", []int{13}, []int{100}, false}, // the region of the query itself is left out
+		{"@a.go:24", []int{}, []int{}, false},
+		{"for _, item := range items {\n\tif item > 0 {\n\t\tcount += item\n\t}\n}", []int{3, 13}, nil, false},
+		{"@a.go:999", nil, nil, true},
+		{"x", nil, nil, true}, // too short for a shingle
+	}
+	for _, test := range tests {
+		lines, scores := []int{}, []int{}
+		err := Search(test.query, nil, func(result cotypes.Result) bool {
+			lines, scores = append(lines, result.Line), append(scores, result.Score)
+			return true
+		})
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.query)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q: %v", test.query, err)
+		} else if !reflect.DeepEqual(lines, test.lines) || (test.scores != nil && !reflect.DeepEqual(scores, test.scores)) {
+			t.Errorf("%q: got lines %v (scores %v), expected %v (scores %v)", test.query, lines, scores, test.lines, test.scores)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+/* 
+** @name: cosyntax
+** @author: Timo Kats
+** @description: Structural search: finds Go code by the shape of its syntax tree instead of its text.
+** @note: A pattern is Go code in which $name matches any expression, statement or name (the same name has to match the same code) and ... matches any number of arguments, statements or fields.
+*/
+
+package cosyntax
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"regexp"
+	"strings"
+
+	coparse "codis/lib/coparse"
+	cosearch "codis/lib/cosearch"
+	cotypes "codis/lib/cotypes"
+	coutils "codis/lib/coutils"
+)
+
+// globals
+
+var Filetypes = []string{"go"}
+var placeholder = "__codis_"
+var ellipsis = "__codis_ellipsis__"
+var variablePattern = regexp.MustCompile(`\$(\w+)`)
+var ellipsisPattern = regexp.MustCompile(`(^|[^\w)\]\[.])\.\.\.`)
+var filterPattern = regexp.MustCompile(`\s+(!?has)\s+`)
+var posType = reflect.TypeOf(token.NoPos)
+var ignoredFields = []string{"Obj", "Scope", "Doc", "Comment", "Comments", "Unresolved", "Imports"}
+
+// structs
+
+type Filter struct {
+	Pattern []ast.Node
+	Negate  bool // the match may not contain the pattern (!has)
+}
+
+type Query struct {
+	Pattern []ast.Node // an expression, a declaration or a sequence of statements
+	Filters []Filter
+}
+
+type matcher struct {
+	file     *token.File
+	source   string
+	bindings map[string]string
+}
+
+/* 
+** @name: parsePattern
+** @description: Parses a pattern as a Go expression, a sequence of statements or a declaration (in that order).
+*/
+func parsePattern(pattern string) ([]ast.Node, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil, errors.New("empty pattern")
+	}
+	pattern = ellipsisPattern.ReplaceAllString(pattern, "${1}" + ellipsis)
+	pattern = variablePattern.ReplaceAllString(pattern, placeholder + "$1")
+	if expr, err := parser.ParseExpr(pattern); err == nil {
+		return []ast.Node{expr}, nil
+	}
+	if file, err := parser.ParseFile(token.NewFileSet(), "", "package p\nfunc _() {\n" + pattern + "\n}", parser.SkipObjectResolution); err == nil {
+		nodes := []ast.Node{}
+		for _, stmt := range file.Decls[0].(*ast.FuncDecl).Body.List {
+			nodes = append(nodes, stmt)
+		}
+		if len(nodes) > 0 {
+			return nodes, nil
+		}
+	}
+	if file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n" + pattern, parser.SkipObjectResolution); err == nil && len(file.Decls) == 1 {
+		return []ast.Node{file.Decls[0]}, nil
+	}
+	return nil, errors.New("the pattern is not a Go expression, statement or declaration")
+}
+
+/* 
+** @name: splitQuery
+** @description: Parses a pattern and the has/!has filters after it. A keyword only splits the query where the code before and after it parses (tried from left to right), so has can also be a name in a pattern.
+*/
+func splitQuery(query string) ([]ast.Node, []Filter, error) {
+	var filterErr error
+	for _, split := range filterPattern.FindAllStringSubmatchIndex(query, -1) {
+		nodes, err := parsePattern(query[:split[0]])
+		if err != nil {
+			continue
+		}
+		operator := query[split[2]:split[3]]
+		filterNodes, filters, err := splitQuery(query[split[1]:])
+		if err != nil {
+			if filterErr == nil {
+				filterErr = errors.New(operator + ": " + err.Error())
+			}
+			continue
+		}
+		return nodes, append([]Filter{{filterNodes, operator == "!has"}}, filters...), nil
+	}
+	nodes, err := parsePattern(query)
+	if err != nil && filterErr != nil {
+		return nil, nil, filterErr
+	}
+	return nodes, nil, err
+}
+
+/* 
+** @name: Parse
+** @description: Parses a structural query: a pattern, optionally followed by has <pattern> or !has <pattern> filters.
+*/
+func Parse(query string) (Query, error) {
+	nodes, filters, err := splitQuery(query)
+	if err != nil {
+		return Query{}, err
+	}
+	return Query{nodes, filters}, nil
+}
+
+/* 
+** @name: placeholderName
+** @description: Returns the name of a placeholder ($name or ...) if a pattern node is one.
+*/
+func placeholderName(value reflect.Value) (string, bool) {
+	if (value.Kind() != reflect.Interface && value.Kind() != reflect.Ptr) || value.IsNil() {
+		return "", false
+	}
+	var ident *ast.Ident
+	switch node := value.Interface().(type) {
+		case *ast.Ident:
+			ident = node
+		case *ast.ExprStmt:
+			ident, _ = node.X.(*ast.Ident)
+		case *ast.Field:
+			if len(node.Names) == 0 {
+				ident, _ = node.Type.(*ast.Ident)
+			}
+	}
+	if ident == nil || !strings.HasPrefix(ident.Name, placeholder) {
+		return "", false
+	}
+	return strings.TrimPrefix(ident.Name, placeholder), true
+}
+
+/* 
+** @name: isEllipsis
+** @description: Returns true if a pattern node is ... (which matches any number of list elements).
+*/
+func isEllipsis(value reflect.Value) bool {
+	name, ok := placeholderName(value)
+	return ok && placeholder + name == ellipsis
+}
+
+/* 
+** @name: text
+** @description: Returns the source of a node with its whitespace normalized (to compare the code of placeholders).
+*/
+func (m *matcher) text(node ast.Node) string {
+	start, end := m.file.Offset(node.Pos()), m.file.Offset(node.End())
+	if start < 0 || end > len(m.source) || start > end {
+		return ""
+	}
+	return strings.Join(strings.Fields(m.source[start:end]), " ")
+}
+
+/* 
+** @name: save
+** @description: Returns a copy of the placeholder bindings (to undo them when a branch fails).
+*/
+func (m *matcher) save() map[string]string {
+	bindings := make(map[string]string, len(m.bindings))
+	for name, code := range m.bindings {
+		bindings[name] = code
+	}
+	return bindings
+}
+
+/* 
+** @name: restore
+** @description: Undoes the bindings that were made after save.
+*/
+func (m *matcher) restore(bindings map[string]string) {
+	m.bindings = make(map[string]string, len(bindings))
+	for name, code := range bindings {
+		m.bindings[name] = code
+	}
+}
+
+/* 
+** @name: match
+** @description: Returns true if a node of the source has the shape of a pattern node (placeholders bind to the code they match).
+*/
+func (m *matcher) match(pattern reflect.Value, target reflect.Value) bool {
+	if name, ok := placeholderName(pattern); ok {
+		if (target.Kind() != reflect.Interface && target.Kind() != reflect.Ptr) || target.IsNil() {
+			return false
+		}
+		node, ok := target.Interface().(ast.Node)
+		if !ok {
+			return false
+		} else if name == "_" {
+			return true
+		} else if code, ok := m.bindings[name]; ok {
+			return code == m.text(node)
+		}
+		m.bindings[name] = m.text(node)
+		return true
+	}
+	switch pattern.Kind() {
+		case reflect.Interface, reflect.Ptr:
+			if pattern.IsNil() || target.IsNil() {
+				return pattern.IsNil() == target.IsNil()
+			}
+			if pattern.Kind() == reflect.Ptr && pattern.Type() != target.Type() {
+				return false
+			}
+			return m.match(pattern.Elem(), target.Elem())
+		case reflect.Struct:
+			if pattern.Type() != target.Type() {
+				return false
+			}
+			for field := 0; field < pattern.NumField(); field++ {
+				structField := pattern.Type().Field(field)
+				if structField.Type == posType || coutils.ContainsString(ignoredFields, structField.Name) {
+					continue
+				}
+				if !m.match(pattern.Field(field), target.Field(field)) {
+					return false
+				}
+			}
+			return true
+		case reflect.Slice:
+			return m.matchList(pattern, target)
+		default:
+			return pattern.Type() == target.Type() && pattern.Interface() == target.Interface()
+	}
+}
+
+/* 
+** @name: matchList
+** @description: Returns true if a list of the source matches a list of the pattern (... skips any number of elements).
+*/
+func (m *matcher) matchList(pattern reflect.Value, target reflect.Value) bool {
+	if pattern.Len() == 0 {
+		return target.Len() == 0
+	}
+	saved := m.save()
+	if isEllipsis(pattern.Index(0)) {
+		for skip := 0; skip <= target.Len(); skip++ {
+			if m.matchList(pattern.Slice(1, pattern.Len()), target.Slice(skip, target.Len())) {
+				return true
+			}
+			m.restore(saved)
+		}
+		return false
+	}
+	if target.Len() > 0 && m.match(pattern.Index(0), target.Index(0)) && m.matchList(pattern.Slice(1, pattern.Len()), target.Slice(1, target.Len())) {
+		return true
+	}
+	m.restore(saved)
+	return false
+}
+
+/* 
+** @name: find
+** @description: Calls found for every node in a tree that matches a pattern (the first statement when the pattern is a sequence). Stops when found returns false.
+*/
+func (m *matcher) find(pattern []ast.Node, root ast.Node, found func(ast.Node) bool) bool {
+	sequence := []ast.Stmt{}
+	for _, node := range pattern {
+		if stmt, ok := node.(ast.Stmt); ok && len(pattern) > 1 {
+			sequence = append(sequence, stmt)
+		}
+	}
+	sequence = append(sequence, &ast.ExprStmt{X: &ast.Ident{Name: ellipsis}})
+	running := true
+	ast.Inspect(root, func(node ast.Node) bool {
+		if node == nil || !running {
+			return false
+		}
+		saved := m.save()
+		if len(pattern) == 1 {
+			if m.match(reflect.ValueOf(pattern[0]), reflect.ValueOf(node)) {
+				running = found(node)
+			}
+			m.restore(saved)
+			return running
+		}
+		var list []ast.Stmt
+		switch node := node.(type) {
+			case *ast.BlockStmt:
+				list = node.List
+			case *ast.CaseClause:
+				list = node.Body
+			case *ast.CommClause:
+				list = node.Body
+		}
+		for start := 0; start < len(list) && running; start++ {
+			if m.matchList(reflect.ValueOf(sequence), reflect.ValueOf(list[start:])) {
+				running = found(list[start])
+			}
+			m.restore(saved)
+		}
+		return running
+	})
+	return running
+}
+
+/* 
+** @name: searchFile
+** @description: Streams the lines of an indexed file where code that matches a query starts. Returns false when emit does.
+*/
+func searchFile(query Query, indexes []int, emit func(cotypes.Result) bool) bool {
+	lines := []string{}
+	for _, index := range indexes {
+		lines = append(lines, coparse.LabeledRows[coparse.OrderedKeys[index]])
+	}
+	source := strings.Join(lines, "\n")
+	fileSet := token.NewFileSet()
+	file, _ := parser.ParseFile(fileSet, "", source, parser.SkipObjectResolution)
+	if file == nil {
+		return true
+	}
+	m := &matcher{fileSet.File(file.Pos()), source, make(map[string]string)}
+	emitted := make(map[int]bool)
+	return m.find(query.Pattern, file, func(node ast.Node) bool {
+		for _, filter := range query.Filters {
+			contains := false
+			m.find(filter.Pattern, node, func(ast.Node) bool {
+				contains = true
+				return false
+			})
+			if contains == filter.Negate {
+				return true
+			}
+		}
+		start, end := fileSet.Position(node.Pos()), fileSet.Position(node.End())
+		if start.Line < 1 || start.Line > len(indexes) || emitted[start.Line] {
+			return true
+		}
+		emitted[start.Line] = true
+		column := len(lines[start.Line-1])
+		if end.Line == start.Line {
+			column = end.Column - 1
+		}
+		return emit(cosearch.NewResult(indexes[start.Line-1], 1, [][2]int{{start.Column - 1, column}}))
+	})
+}
+
+/* 
+** @name: Search
+** @description: Streams the lines of the indexed Go files where code that matches a structural query starts. Stops when emit returns false.
+*/
+func Search(query string, contextCategories []string, emit func(cotypes.Result) bool) error {
+	structural, err := Parse(query)
+	if err != nil {
+		return err
+	}
+	paths, files := []string{}, make(map[string][]int)
+	for index, key := range coparse.OrderedKeys {
+		if !coutils.ContainsString(Filetypes, key.Filetype) || (len(contextCategories) > 0 && !coutils.ContainsString(contextCategories, key.Category)) {
+			continue
+		}
+		if _, ok := files[key.FilePath]; !ok {
+			paths = append(paths, key.FilePath)
+		}
+		files[key.FilePath] = append(files[key.FilePath], index)
+	}
+	for _, path := range paths {
+		if !searchFile(structural, files[path], emit) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+/* 
+** @name: cosyntax_test
+** @author: Timo Kats
+** @description: Runs structural queries against a small indexed Go file.
+*/
+
+package cosyntax
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	coparse "codis/lib/coparse"
+	cotypes "codis/lib/cotypes"
+)
+
+// globals
+
+var source = `package p
+
+import "fmt"
+
+func a(items []int) []int {
+	items = append(items, 1)
+	other := append(items, 2)
+	fmt.Println()
+	fmt.Println("x", 1, items)
+	return other
+}
+
+func b(has bool, m *T) error {
+	if has {
+		m.has = true
+	}
+	err := run()
+	if err != nil {
+		fmt.Println(err)
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+`
+
+/* 
+** @name: index
+** @description: Indexes a temporary project with the source as p.go.
+*/
+func index(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "p.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := coparse.Reindex(root); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/* 
+** @name: TestSearch
+** @description: Checks the lines that structural queries match ($x bindings, ... in arguments and statements, has/!has filters and has as a name).
+*/
+func TestSearch(t *testing.T) {
+	index(t)
+	tests := []struct {
+		query string
+		lines []int
+	}{
+		{"$x = append($x, $_)", []int{6}}, // the same name has to match the same code
+		{"$x := append($y, $_)", []int{7}},
+		{"$x = append($y, $y)", []int{}},
+		{"fmt.Println(...)", []int{8, 9, 19}},
+		{"fmt.Println($_, ...)", []int{9, 19}},
+		{"fmt.Println($_, $_, $_, ...)", []int{9}},
+		{"$v := run(); ...; return nil", []int{17}},
+		{"$v := run(); return nil", []int{}},
+		{"if $err != nil { ... } !has return ...", []int{18}},
+		{"if $err != nil { ... } has return $err", []int{21}},
+		{"if has { ... }", []int{14}},
+		{"$m.has = true", []int{15}},
+		{"if has { ... } has $m.has = true", []int{14}},
+	}
+	for _, test := range tests {
+		lines := []int{}
+		err := Search(test.query, nil, func(result cotypes.Result) bool {
+			lines = append(lines, result.Line)
+			return true
+		})
+		if err != nil {
+			t.Errorf("%q: %v", test.query, err)
+		} else if !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("%q: got lines %v, expected %v", test.query, lines, test.lines)
+		}
+	}
+}
+
+/* 
+** @name: TestParse
+** @description: Checks where a query is split into a pattern and has/!has filters.
+*/
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query   string
+		filters []bool // negated per filter
+		invalid bool
+	}{
+		{"f($x)", []bool{}, false},
+		{"f($x) has g() !has h()", []bool{false, true}, false},
+		{"if has { ... }", []bool{}, false},
+		{"x := 1; has := 2", []bool{}, false},
+		{"if has { ... } !has return", []bool{true}, false},
+		{"f( has g(", nil, true},
+		{"f() has g(", nil, true},
+	}
+	for _, test := range tests {
+		query, err := Parse(test.query)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.query)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q: %v", test.query, err)
+			continue
+		}
+		negated := []bool{}
+		for _, filter := range query.Filters {
+			negated = append(negated, filter.Negate)
+		}
+		if !reflect.DeepEqual(negated, test.filters) {
+			t.Errorf("%q: got filters %v, expected %v", test.query, negated, test.filters)
+		}
+	}
+}
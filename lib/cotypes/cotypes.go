@@ -6,6 +6,8 @@
 
 package cotypes
 
+import "regexp"
+
 type RowLabel struct {
 	Filename    string
 	Filetype    string
@@ -17,9 +19,50 @@ type RowLabel struct {
 	HasComment 	bool
 	ImportedCode 	string
 	HasVariableDeclaration bool
+	CustomLabels uint64
 	Linenumber  int
 }
 
+type CustomLabel struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+type Symbol struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	Filename   string `json:"filename"`
+	FilePath   string `json:"path"`
+	Linenumber int    `json:"line"`
+	Index      int    `json:"-"`
+}
+
+type Result struct {
+	Path          string   `json:"path"`
+	Line          int      `json:"line"`
+	ColumnStart   int      `json:"column_start"`
+	ColumnEnd     int      `json:"column_end"`
+	Matches       [][2]int `json:"matches"`
+	Score         int      `json:"score"`
+	Labels        []string `json:"labels"`
+	Text          string   `json:"text"`
+	ContextBefore []string `json:"context_before"`
+	ContextAfter  []string `json:"context_after"`
+	Index         int      `json:"-"`
+}
+
+type Snippet struct {
+	Start int
+	End   int
+	Hits  []int
+}
+
+type FileGroup struct {
+	Path     string
+	Hits     []int
+	Snippets []Snippet
+}
+
 type Indecies struct {
 	QueryIndex int
 	ResultIndex int
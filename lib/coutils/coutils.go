@@ -26,6 +26,67 @@ func CropString(line string, max int, end string) string {
   }
 }
 
+/* 
+** @name: CropRunes
+** @description: Replaces tabs with spaces and truncates a string to a max number of characters (for panes). 
+*/
+func CropRunes(line string, max int) string {
+  runes := []rune(strings.ReplaceAll(line, "\t", "    "))
+  if max <= 0 {
+    return ""
+  } else if len(runes) > max {
+    return string(runes[:max])
+  }
+  return string(runes)
+}
+
+/* 
+** @name: CropAround
+** @description: Crops a line to a width around its first match. Returns the characters and which of them matched. 
+** @note: Matches are 0-based byte spans, tabs are replaced by 4 spaces.
+*/
+func CropAround(line string, matches [][2]int, width int) (string, []bool) {
+  display, mask := []rune{}, []bool{}
+  for offset, char := range line {
+    matched := false
+    for _, match := range matches {
+      if offset >= match[0] && offset < match[1] {
+        matched = true
+      }
+    }
+    if char == '\t' {
+      display = append(display, ' ', ' ', ' ', ' ')
+      mask = append(mask, matched, matched, matched, matched)
+    } else {
+      display = append(display, char)
+      mask = append(mask, matched)
+    }
+  }
+  if width <= 0 {
+    return "", []bool{}
+  } else if len(display) <= width {
+    return string(display), mask
+  }
+  first := 0
+  for index, matched := range mask {
+    if matched {
+      first = index
+      break
+    }
+  }
+  start := first - width/3
+  if start > len(display) - width { start = len(display) - width }
+  if start < 0 { start = 0 }
+  end := start + width
+  if start > 0 {
+    display[start], mask[start] = '…', false
+  }
+  if end < len(display) {
+    display[end-1], mask[end-1] = '…', false
+  }
+  return string(display[start:end]), mask[start:end]
+}
+
 func tabCorrectedLen(line string) int {
   len := 0
   for _, char := range line {
@@ -71,6 +132,19 @@ func ContainsString(s []string, e string) bool {
 	return false
 }
 
+/* 
+** @name: IndexOf 
+** @description: Returns the index of a string in a list (or -1). 
+*/
+func IndexOf(s []string, e string) int {
+	for i, a := range s {
+		if a == e {
+			return i
+		}
+	}
+	return -1
+}
+
 /* 
 ** @name: containsint
 ** @description: Returns true if a list contains an integer.
@@ -97,7 +171,9 @@ func DeleteInt(s []int, e int) []int {
 func SubsetSlice(s []string, i []int) []string {
   newSlice := []string{}
   for _, index := range i {
-    newSlice = append(newSlice, s[index])
+    if index < len(s) {
+      newSlice = append(newSlice, s[index])
+    }
   }
   return newSlice
 }
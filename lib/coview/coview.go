@@ -0,0 +1,182 @@
+/* 
+** @name: coview
+** @author: Timo Kats
+** @description: Full file viewer with syntax highlighting, line numbers, scrolling and in-file search.
+*/
+
+package coview
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/formatters"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/charmbracelet/lipgloss"
+
+	coparse "codis/lib/coparse"
+	coutils "codis/lib/coutils"
+)
+
+// globals
+
+var Theme = "monokai"
+
+// structs
+
+type Viewer struct {
+	FilePath string
+	Filename string
+	Lines []string
+	Highlighted []string
+	Cursor int
+	Top int
+	Search string
+	Message string
+}
+
+/* 
+** @name: Highlight
+** @description: Returns the lines of a file with (terminal) syntax highlighting based on its language.
+*/
+func Highlight(filename string, lines []string) []string {
+	lexer := lexers.Match(filename)
+	if lexer == nil {
+		lexer = lexers.Analyse(strings.Join(lines, "\n"))
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	style := styles.Get(Theme)
+	if style == nil {
+		style = styles.Fallback
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, strings.Join(lines, "\n"))
+	if err != nil {
+		return lines
+	}
+	highlighted := []string{}
+	for _, lineTokens := range chroma.SplitTokensIntoLines(iterator.Tokens()) {
+		for index := range lineTokens {
+			lineTokens[index].Value = strings.TrimSuffix(lineTokens[index].Value, "\n")
+		}
+		buffer := bytes.Buffer{}
+		if err := formatters.TTY256.Format(&buffer, style, chroma.Literator(lineTokens...)); err != nil {
+			return lines
+		}
+		highlighted = append(highlighted, buffer.String())
+	}
+	for len(highlighted) < len(lines) {
+		highlighted = append(highlighted, lines[len(highlighted)])
+	}
+	return highlighted
+}
+
+/* 
+** @name: NewViewer
+** @description: Opens an indexed file in the viewer with the cursor on a (1-based) line.
+*/
+func NewViewer(filePath string, linenumber int) *Viewer {
+	viewer := &Viewer{FilePath: filePath}
+	for _, key := range coparse.OrderedKeys {
+		if key.FilePath == filePath {
+			viewer.Filename = key.Filename
+			viewer.Lines = append(viewer.Lines, strings.ReplaceAll(coparse.LabeledRows[key], "\t", "    "))
+		}
+	}
+	viewer.Highlighted = Highlight(viewer.Filename, viewer.Lines)
+	viewer.Goto(linenumber)
+	return viewer
+}
+
+/* 
+** @name: Goto
+** @description: Moves the cursor to a (1-based) line.
+*/
+func (viewer *Viewer) Goto(linenumber int) {
+	viewer.Cursor = linenumber - 1
+	if viewer.Cursor >= len(viewer.Lines) {
+		viewer.Cursor = len(viewer.Lines) - 1
+	}
+	if viewer.Cursor < 0 {
+		viewer.Cursor = 0
+	}
+}
+
+/* 
+** @name: Move
+** @description: Moves the cursor up (negative) or down (positive) a number of lines.
+*/
+func (viewer *Viewer) Move(lines int) {
+	viewer.Goto(viewer.Cursor + 1 + lines)
+}
+
+/* 
+** @name: Find
+** @description: Moves the cursor to the next (or previous) line that matches the search pattern.
+*/
+func (viewer *Viewer) Find(pattern string, forward bool) {
+	if pattern != "" {
+		viewer.Search = pattern
+	}
+	reQuery, err := regexp.Compile(viewer.Search)
+	if len(viewer.Lines) == 0 {
+		return
+	} else if err != nil || viewer.Search == "" {
+		viewer.Message = "invalid search"
+		return
+	}
+	for step := 1; step <= len(viewer.Lines); step++ {
+		index := (viewer.Cursor + step) % len(viewer.Lines)
+		if !forward {
+			index = (viewer.Cursor - step + len(viewer.Lines)*2) % len(viewer.Lines)
+		}
+		if reQuery.MatchString(viewer.Lines[index]) {
+			viewer.Cursor, viewer.Message = index, ""
+			return
+		}
+	}
+	viewer.Message = "pattern not found: " + viewer.Search
+}
+
+/* 
+** @name: View
+** @description: Returns the visible part of the file (scrolled so the cursor is visible).
+*/
+func (viewer *Viewer) View(width int, height int) string {
+	if viewer.Cursor < viewer.Top {
+		viewer.Top = viewer.Cursor
+	} else if viewer.Cursor >= viewer.Top + height {
+		viewer.Top = viewer.Cursor - height + 1
+	}
+	gutterWidth := len(strconv.Itoa(len(viewer.Lines))) + 2
+	cursorStyle := lipgloss.NewStyle().Reverse(true)
+	lineStyle := lipgloss.NewStyle().MaxWidth(width - gutterWidth)
+	rows := []string{}
+	for index := viewer.Top; index < len(viewer.Lines) && index < viewer.Top + height; index++ {
+		gutter := strconv.Itoa(index + 1) + strings.Repeat(" ", gutterWidth - 1 - len(strconv.Itoa(index + 1)))
+		if index == viewer.Cursor {
+			gutter = cursorStyle.Render(gutter)
+		}
+		rows = append(rows, gutter + " " + lineStyle.Render(viewer.Highlighted[index]))
+	}
+	return strings.Join(rows, "\n")
+}
+
+/* 
+** @name: Status
+** @description: Returns the status line of the viewer (file, line and search).
+*/
+func (viewer *Viewer) Status() string {
+	status := coutils.CropRunes(viewer.Filename, 60) + ", line " + strconv.Itoa(viewer.Cursor + 1) + "/" + strconv.Itoa(len(viewer.Lines))
+	if viewer.Message != "" {
+		status += " | " + viewer.Message
+	} else if viewer.Search != "" {
+		status += " | search: " + viewer.Search
+	}
+	return status
+}
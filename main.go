@@ -7,10 +7,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 	"strconv"
+	"sync"
+	"time"
 	
 	// only external dependencies
 	"github.com/charmbracelet/bubbles/textarea"
@@ -18,20 +23,29 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	tea "github.com/charmbracelet/bubbletea"
 
+	engine "codis/engine"
 	coparse "codis/lib/coparse"
 	coutils "codis/lib/coutils"
 	cotypes "codis/lib/cotypes"
 	cofile "codis/lib/cofile"
 	cosearch "codis/lib/cosearch"
 	coexplore "codis/lib/coexplore"
+	cocli "codis/lib/cocli"
 	cocommands "codis/lib/cocommands"
+	coview "codis/lib/coview"
+	coeditor "codis/lib/coeditor"
+	cosettings "codis/lib/cosettings"
+	cohistory "codis/lib/cohistory"
+	cobookmarks "codis/lib/cobookmarks"
+	coreplace "codis/lib/coreplace"
 	codependencies "codis/lib/codependencies"
 )
 
-// globals that need to remain constant
+// globals that need to remain constant (after indexing)
 
-var fullTree, _ = coexplore.NewTree(coparse.CurrentDirectory)
-var rootFiles = codependencies.GetRootFiles()
+var index *engine.Engine
+var rootFiles []string
+var searches sync.WaitGroup // the background searches that are still running (also cancelled ones)
 
 // structs 
 
@@ -57,6 +71,7 @@ func ResultStyle() *Styles {
 type model struct {
 	indecies cotypes.Indecies
 	query cotypes.Query
+	results []cotypes.Result
 	width int
 	height int 
 	viewDirOnly bool
@@ -68,6 +83,58 @@ type model struct {
 	resultStyle *Styles
 	contextCategories []int
 	contextComment []int
+	contextIncremental []int
+	typeId int
+	searchId int
+	searching bool
+	partial bool // the last search was stopped before it returned all results
+	cancelSearch context.CancelFunc
+	viewer *coview.Viewer
+	viewerPrompt string
+	status string
+	groupedView bool
+	collapsed map[string]bool
+	lastSearch *cohistory.Entry
+	historyPosition int
+	historyPrefix string
+	picker []string
+	pickerIndex int
+	pendingMark *cobookmarks.Bookmark
+	marks bool
+	markIndex int
+	refining bool
+	refineSource []cotypes.Result
+	refineMode int
+	crumb string
+	breadcrumbs []breadcrumb
+	replaceHits []coreplace.Hit
+	replaceIndex int
+}
+
+type breadcrumb struct {
+	query string
+	queryIndex int
+	results []cotypes.Result
+}
+
+type searchResultsMsg struct {
+	id int
+	results []cotypes.Result
+	next tea.Cmd
+}
+
+type searchDoneMsg struct {
+	id int
+	err error
+}
+
+type debounceMsg struct {
+	id int
+}
+
+type editorDoneMsg struct {
+	filePath string
+	err error
 }
 
 /* 
@@ -88,7 +155,8 @@ func New(query cotypes.Query) *model {
 	return &model{formMode: false, indecies: indecies, query: query, 
 	viewDirOnly: false, commandMode: false, queryField: queryField, 
 	resultField: resultField, queryStyle: queryStyle, resultStyle: resultStyle,
-	contextCategories: []int{}, contextComment: []int{1,0},
+	contextCategories: []int{}, contextComment: []int{1,0}, contextIncremental: []int{0,1},
+	collapsed: make(map[string]bool),
 	}
 } 
 
@@ -102,26 +170,236 @@ func (m model) Init() tea.Cmd {
 
 // keypresses
 
+/* 
+** @name: resize
+** @description: Fits the result box and the crop width of the results to the terminal (or to the settings).
+*/
+func resize(m model) model {
+	width, height := layoutSize(m)
+	m.resultField.SetWidth(width - 2)
+	m.resultField.SetHeight(height)
+	if cosettings.CropWidth == 0 {
+		cosearch.CropWidth = width - 12
+	}
+	if len(m.results) > 0 && !m.commandMode {
+		m.query.Result, m.query.ResultLocations = cosearch.AppendResults([]string{}, []string{}, m.results)
+		m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+	}
+	return m
+}
+
 /* 
 ** @name: KeyEscape 
 ** @description: Empties the current set of results. 
 */
 func KeyEscape(m model) (tea.Model, tea.Cmd) {
+	m = stopSearch(m)
+	m.status = ""
 	m.queryField.Reset()
+	m.historyPosition = 0
+	m.refining, m.refineSource, m.breadcrumbs = false, nil, nil
 	m.resultField.Reset()
 	m.indecies.ResultIndex = 0
 	return m, nil
 }
 
+/* 
+** @name: searchFilter 
+** @description: Returns the engine filter that matches the settings form. 
+*/
+func searchFilter(m model) engine.Filter {
+	categoryContext := coutils.SubsetSlice(coparse.ContextCategories, m.contextCategories)
+	return engine.Filter{Categories: categoryContext, ExcludeComments: m.contextComment[0] == 0}
+}
+
+/* 
+** @name: stopSearch 
+** @description: Cancels the search that is running in the background (if any). 
+*/
+func stopSearch(m model) model {
+	if m.cancelSearch != nil {
+		m.cancelSearch()
+		m.cancelSearch = nil
+	}
+	m.partial = m.partial || m.searching
+	m.searching = false
+	m.searchId += 1
+	return m
+}
+
+/* 
+** @name: waitSearch 
+** @description: Cancels the search that is running in the background and waits until every (cancelled) search has returned (before changing globals that they read, like the context sizes). 
+*/
+func waitSearch(m model) model {
+	m = stopSearch(m)
+	searches.Wait()
+	return m
+}
+
+/* 
+** @name: waitForResults 
+** @description: Returns a command that waits for the next batch of results of a background search. 
+*/
+func waitForResults(id int, results chan cotypes.Result, errs chan error) tea.Cmd {
+	return func() tea.Msg {
+		result, ok := <-results
+		if !ok {
+			return searchDoneMsg{id, <-errs}
+		}
+		batch := []cotypes.Result{result}
+		for len(batch) < 100 {
+			select {
+			case result, ok := <-results:
+				if !ok {
+					return searchResultsMsg{id, batch, waitForResults(id, results, errs)}
+				}
+				batch = append(batch, result)
+			default:
+				return searchResultsMsg{id, batch, waitForResults(id, results, errs)}
+			}
+		}
+		return searchResultsMsg{id, batch, waitForResults(id, results, errs)}
+	}
+}
+
+/* 
+** @name: startSearch 
+** @description: Cancels the previous search and starts a quick, fuzzy or symbol search in the background (over the refined results when refining). 
+*/
+func startSearch(m model) (model, tea.Cmd) {
+	m = stopSearch(m)
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelSearch, m.searching, m.partial = cancel, true, false
+	m.results, m.collapsed = []cotypes.Result{}, make(map[string]bool)
+	m.query.Result, m.query.ResultLocations = []string{"searching..."}, []string{"None"}
+	coparse.QueryCounts = coparse.ReturnEmptyQueryResults()
+	results, errs := make(chan cotypes.Result, 256), make(chan error, 1)
+	queryIndex, query, filter := m.indecies.QueryIndex, m.query.Query, searchFilter(m)
+	emit := func(result cotypes.Result) bool {
+		select {
+		case results <- result:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	refining, source := m.refining, m.refineSource
+	searches.Add(1)
+	go func() {
+		defer searches.Done()
+		var err error
+		if refining {
+			err = index.RefineFunc(ctx, query, queryIndex == 1, source, emit)
+		} else if queryIndex == 0 {
+			err = index.SearchFunc(ctx, query, filter, emit)
+		} else if queryIndex == 1 {
+			err = index.FuzzyFunc(ctx, query, filter, emit)
+		} else if queryIndex == 5 {
+			err = index.SymbolFunc(ctx, query, emit)
+		} else if queryIndex == 6 {
+			err = index.StructuralFunc(ctx, query, filter, emit)
+		} else if queryIndex == 7 {
+			err = index.SimilarFunc(ctx, query, filter, emit)
+		}
+		errs <- err
+		close(results)
+	}()
+	return m, waitForResults(m.searchId, results, errs)
+}
+
+/* 
+** @name: debounce 
+** @description: Returns a command that fires when the query field hasn't changed for a moment. 
+*/
+func debounce(id int) tea.Cmd {
+	return tea.Tick(200 * time.Millisecond, func(time.Time) tea.Msg {
+		return debounceMsg{id}
+	})
+}
+
+/* 
+** @name: searchAsYouType 
+** @description: Runs the (latest) contents of the query field as a search. 
+*/
+func searchAsYouType(m model, msg debounceMsg) (tea.Model, tea.Cmd) {
+	if msg.id != m.typeId || m.commandMode || m.formMode {
+		return m, nil
+	}
+	m.indecies.ResultIndex = 0
+	m.query.Query = m.queryField.Value()
+	if !m.refining {
+		m.crumb = m.query.Query
+	}
+	if m.query.Query == "" && m.refining {
+		return showResults(m, m.refineSource), nil
+	} else if m.query.Query == "" {
+		m = stopSearch(m)
+		m.results = []cotypes.Result{}
+		m.query.Result, m.query.ResultLocations = []string{"None"}, []string{"None"}
+		m.resultField.Reset()
+		return m, nil
+	}
+	m, cmd := startSearch(m)
+	m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+	return m, cmd
+}
+
+/* 
+** @name: receiveResults 
+** @description: Adds a batch of streamed results to the view. 
+*/
+func receiveResults(m model, msg searchResultsMsg) (tea.Model, tea.Cmd) {
+	if msg.id != m.searchId {
+		return m, nil
+	}
+	if len(m.results) == 0 {
+		m.query.Result, m.query.ResultLocations = []string{}, []string{}
+	}
+	m.results = append(m.results, msg.results...)
+	m.query.Result, m.query.ResultLocations = cosearch.AppendResults(m.query.Result, m.query.ResultLocations, msg.results)
+	m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+	return m, msg.next
+}
+
+/* 
+** @name: finishSearch 
+** @description: Marks the background search as done (or shows why it failed). 
+*/
+func finishSearch(m model, msg searchDoneMsg) (tea.Model, tea.Cmd) {
+	if msg.id != m.searchId {
+		return m, nil
+	}
+	m.searching, m.cancelSearch = false, nil
+	if msg.err != nil && msg.err != context.Canceled {
+		m.query.Result, m.query.ResultLocations = []string{"invalid query"}, []string{"None"}
+	} else if len(m.results) == 0 {
+		m.query.Result, m.query.ResultLocations = []string{"None"}, []string{"None"}
+	}
+	m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+	return m, nil
+}
+
 func KeyEnterSearch (m model) (tea.Model, tea.Cmd) {
 	categoryContext := coutils.SubsetSlice(coparse.ContextCategories, m.contextCategories)
-	infoContext := bool(m.contextComment[0] == 1)
-	if m.indecies.QueryIndex == 0 { 
-		m.query.Result, m.query.ResultLocations = cosearch.BasicQuery(m.query.Query, categoryContext, infoContext)
-	} else if m.indecies.QueryIndex == 1 {
-		m.query.Result, m.query.ResultLocations = cosearch.FuzzyQuery(m.query.Query, categoryContext, infoContext)
+	if m.refining {
+		return refine(m)
+	}
+	m.breadcrumbs, m.crumb = nil, m.query.Query
+	if strings.TrimSpace(m.query.Query) != "" {
+		entry := searchEntry(m)
+		m.lastSearch = &entry
+		if err := cohistory.Add(entry); err != nil {
+			m.status = "history: " + err.Error()
+		}
+	}
+	if m.indecies.QueryIndex == 0 || m.indecies.QueryIndex == 1 || m.indecies.QueryIndex == 5 || m.indecies.QueryIndex == 6 || m.indecies.QueryIndex == 7 {
+		var cmd tea.Cmd
+		m, cmd = startSearch(m)
+		m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+		return m, cmd
 	} else if m.indecies.QueryIndex == 2 {
-		m.query.Result, m.query.ResultLocations = coexplore.Show(fullTree, 0, 5, m.query.Query, m.viewDirOnly, m.indecies.InfoIndex)
+		m.query.Result, m.query.ResultLocations = coexplore.Show(index.FullTree(), 0, coexplore.MaxDepth, m.query.Query, m.viewDirOnly, m.indecies.InfoIndex)
 	} else if m.indecies.QueryIndex == 3 {
 		m.query.Result, m.query.ResultLocations = codependencies.Show(m.indecies.InfoIndex, rootFiles, m.query.Query)
 	} else if m.indecies.QueryIndex == 4 {
@@ -131,6 +409,436 @@ func KeyEnterSearch (m model) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+/* 
+** @name: showResults
+** @description: Shows a set of results that is already known (e.g. from a breadcrumb).
+*/
+func showResults(m model, results []cotypes.Result) model {
+	m = stopSearch(m)
+	m.results, m.collapsed = results, make(map[string]bool)
+	m.indecies.ResultIndex = 0
+	m.query.Result, m.query.ResultLocations = cosearch.AppendResults([]string{}, []string{}, results)
+	if len(results) == 0 {
+		m.query.Result, m.query.ResultLocations = []string{"None"}, []string{"None"}
+	}
+	m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+	return m
+}
+
+/* 
+** @name: KeyRefine
+** @description: Starts (or cancels) a refinement: the next quick or fuzzy query only searches the current results.
+*/
+func KeyRefine(m model) (tea.Model, tea.Cmd) {
+	if m.refining {
+		m.refining, m.status = false, ""
+		m.indecies.QueryIndex = m.refineMode
+		m.queryStyle = QueryStyle((m.indecies.QueryIndex % 6) + 10)
+		m.queryField.Reset()
+		return showResults(m, m.refineSource), nil
+	} else if !hasResultList(m) || m.searching {
+		m.status = "nothing to refine, run a quick, fuzzy or symbol search first"
+		return m, nil
+	}
+	m.refining, m.refineSource, m.refineMode = true, m.results, m.indecies.QueryIndex
+	if m.indecies.QueryIndex != 1 {
+		m.indecies.QueryIndex = 0
+	}
+	m.queryStyle = QueryStyle((m.indecies.QueryIndex % 6) + 10)
+	m.queryField.Reset()
+	m.queryField.Focus()
+	m.status = "type a query to search within these " + strconv.Itoa(len(m.results)) + " results (tab switches between quick and fuzzy, ctrl+r cancels)"
+	return m, nil
+}
+
+/* 
+** @name: refine
+** @description: Runs the query over the refined results and adds the previous results to the breadcrumbs.
+*/
+func refine(m model) (tea.Model, tea.Cmd) {
+	if strings.TrimSpace(m.query.Query) == "" {
+		return KeyRefine(m)
+	}
+	m.breadcrumbs = append(m.breadcrumbs, breadcrumb{m.crumb, m.refineMode, m.refineSource})
+	m, cmd := startSearch(m)
+	m.refining, m.refineSource, m.crumb, m.status = false, nil, m.query.Query, ""
+	m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+	return m, cmd
+}
+
+/* 
+** @name: KeyPopRefinement
+** @description: Goes back to the results before the last refinement.
+*/
+func KeyPopRefinement(m model) (tea.Model, tea.Cmd) {
+	if m.refining {
+		return KeyRefine(m)
+	} else if len(m.breadcrumbs) == 0 {
+		m.status = "there is no refinement to go back from"
+		return m, nil
+	}
+	previous := m.breadcrumbs[len(m.breadcrumbs)-1]
+	m.breadcrumbs = m.breadcrumbs[:len(m.breadcrumbs)-1]
+	m.crumb, m.indecies.QueryIndex, m.status = previous.query, previous.queryIndex, ""
+	m.queryStyle = QueryStyle((m.indecies.QueryIndex % 6) + 10)
+	return showResults(m, previous.results), nil
+}
+
+/* 
+** @name: breadcrumbTrail
+** @description: Returns the queries of the refinements (the first one is the original search).
+*/
+func breadcrumbTrail(m model) string {
+	if len(m.breadcrumbs) == 0 && !m.refining {
+		return ""
+	}
+	trail := []string{}
+	for _, previous := range m.breadcrumbs {
+		trail = append(trail, previous.query)
+	}
+	trail = append(trail, m.crumb)
+	if m.refining {
+		trail = append(trail, "…")
+	}
+	return "  " + strings.Join(trail, " › ")
+}
+
+/* 
+** @name: searchEntry
+** @description: Returns the current query with its mode and filters (for the history and saved searches).
+*/
+func searchEntry(m model) cohistory.Entry {
+	return cohistory.Entry{
+		Query: m.query.Query,
+		Mode: cohistory.Modes[m.indecies.QueryIndex],
+		Categories: coutils.SubsetSlice(coparse.ContextCategories, m.contextCategories),
+		Comments: m.contextComment[0] == 1,
+	}
+}
+
+/* 
+** @name: runSaved
+** @description: Restores the mode and filters of a saved search and runs it.
+*/
+func runSaved(m model, entry cohistory.Entry) (tea.Model, tea.Cmd) {
+	m.commandMode, m.picker = false, nil
+	m.indecies.QueryIndex, m.indecies.ResultIndex = 0, 0
+	for modeIndex, mode := range cohistory.Modes {
+		if mode == entry.Mode {
+			m.indecies.QueryIndex = modeIndex
+		}
+	}
+	m.queryStyle = QueryStyle((m.indecies.QueryIndex % 6) + 10)
+	m.contextCategories, m.status = []int{}, ""
+	for _, category := range entry.Categories {
+		if categoryIndex := coutils.IndexOf(coparse.ContextCategories, category); categoryIndex != -1 {
+			m.contextCategories = append(m.contextCategories, categoryIndex)
+		} else {
+			m.status += "category " + category + " is not in this index "
+		}
+	}
+	m.contextComment = []int{0,1}
+	if entry.Comments {
+		m.contextComment = []int{1,0}
+	}
+	m.query.Query = entry.Query
+	return KeyEnterSearch(m)
+}
+
+/* 
+** @name: pickerView
+** @description: Returns the list of saved searches with the selected one marked.
+*/
+func pickerView(m model) string {
+	s := strings.Builder{}
+	s.WriteString("\n    SAVED SEARCHES (up/down to select, enter to run, esc to close):\n\n")
+	for pickerIndex, name := range m.picker {
+		marker := "      "
+		if pickerIndex == m.pickerIndex {
+			marker = "    > "
+		}
+		s.WriteString(fmt.Sprintf("%s%-20s %s\n", marker, name, cohistory.Describe(cohistory.Saved[name])))
+	}
+	return s.String()
+}
+
+/* 
+** @name: KeyPicker
+** @description: Handles the keys in the picker with the saved searches.
+*/
+func KeyPicker(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "esc", "q":
+			m.picker = nil
+			m.query.Result, m.query.ResultLocations = []string{""}, []string{"None"}
+		case "up", "k", "ctrl+k":
+			m.pickerIndex = (m.pickerIndex - 1 + len(m.picker)) % len(m.picker)
+		case "down", "j", "ctrl+j":
+			m.pickerIndex = (m.pickerIndex + 1) % len(m.picker)
+		case "enter":
+			return runSaved(m, cohistory.Saved[m.picker[m.pickerIndex]])
+	}
+	if m.picker != nil {
+		m.query.Result = []string{pickerView(m)}
+	}
+	m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+	return m, nil
+}
+
+/* 
+** @name: marksView
+** @description: Returns the list of bookmarks with the selected one marked.
+*/
+func marksView(m model) string {
+	s := strings.Builder{}
+	s.WriteString("\n    BOOKMARKS (up/down to select, enter to open, e to edit, d to remove, esc to close):\n\n")
+	for markIndex, bookmark := range cobookmarks.Bookmarks {
+		marker := "      "
+		if markIndex == m.markIndex {
+			marker = "    > "
+		}
+		s.WriteString(fmt.Sprintf("%s%-3d %s\n", marker, markIndex + 1, cobookmarks.Describe(bookmark)))
+	}
+	return s.String()
+}
+
+/* 
+** @name: showMarks
+** @description: Shows the bookmarks (or closes the view when there are none left).
+*/
+func showMarks(m model) model {
+	m.indecies.ResultIndex = 0
+	if len(cobookmarks.Bookmarks) == 0 {
+		m.marks = false
+		m.query.Result, m.query.ResultLocations = []string{"there are no bookmarks"}, []string{"bookmarks"}
+		return m
+	}
+	m.marks, m.markIndex = true, min(m.markIndex, len(cobookmarks.Bookmarks) - 1)
+	m.query.Result, m.query.ResultLocations = []string{marksView(m)}, []string{"bookmarks"}
+	return m
+}
+
+/* 
+** @name: KeyMarks
+** @description: Handles the keys in the list of bookmarks.
+*/
+func KeyMarks(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "esc", "q":
+			m.marks = false
+			m.query.Result, m.query.ResultLocations = []string{""}, []string{"None"}
+		case "up", "k", "ctrl+k":
+			m.markIndex = (m.markIndex - 1 + len(cobookmarks.Bookmarks)) % len(cobookmarks.Bookmarks)
+		case "down", "j", "ctrl+j":
+			m.markIndex = (m.markIndex + 1) % len(cobookmarks.Bookmarks)
+		case "enter":
+			filePath, linenumber := cobookmarks.Location(cobookmarks.Bookmarks[m.markIndex])
+			m.viewer = coview.NewViewer(filePath, linenumber)
+			if len(m.viewer.Lines) == 0 {
+				m.viewer.Message = "file is not indexed"
+			}
+		case "e":
+			filePath, linenumber := cobookmarks.Location(cobookmarks.Bookmarks[m.markIndex])
+			return openEditor(m, filePath, linenumber)
+		case "d", "delete":
+			if err := cobookmarks.Remove(m.markIndex + 1); err != nil {
+				m.status = err.Error()
+			}
+	}
+	if m.marks {
+		m = showMarks(m)
+	}
+	m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+	return m, nil
+}
+
+/* 
+** @name: replaceView
+** @description: Returns the changed lines of a replacement (the ones around the selected one) with the accepted ones checked.
+*/
+func replaceView(m model) string {
+	width, height := layoutSize(m)
+	s := strings.Builder{}
+	s.WriteString(fmt.Sprintf("\n    REPLACE: %d of %d changes accepted (y/n to accept/reject, space to toggle, a/r for all, w to write the files, p to write %s, esc to cancel):\n\n", coreplace.Accepted(m.replaceHits), len(m.replaceHits), coreplace.PatchFile))
+	visible := max(1, (height - 3) / 3)
+	start := min(max(0, m.replaceIndex - visible / 2), max(0, len(m.replaceHits) - visible))
+	for hitIndex := start; hitIndex < min(start + visible, len(m.replaceHits)); hitIndex++ {
+		location, oldLine, newLine := coreplace.Describe(m.replaceHits[hitIndex])
+		marker, check := "      ", "[ ]"
+		if hitIndex == m.replaceIndex {
+			marker = "    > "
+		}
+		if m.replaceHits[hitIndex].Accepted {
+			check = "[x]"
+		}
+		s.WriteString(fmt.Sprintf("%s%s %s\n", marker, check, location))
+		s.WriteString("          - " + coutils.CropRunes(oldLine, width - 14) + "\n")
+		s.WriteString("          + " + coutils.CropRunes(newLine, width - 14) + "\n")
+	}
+	return s.String()
+}
+
+/* 
+** @name: closeReplace
+** @description: Leaves the review of a replacement and shows the search results again.
+*/
+func closeReplace(m model) model {
+	m.replaceHits, m.replaceIndex, m.commandMode = nil, 0, false
+	m.query.Query = m.crumb
+	m.queryStyle = QueryStyle((m.indecies.QueryIndex % 6) + 10)
+	return showResults(m, m.results)
+}
+
+/* 
+** @name: writeReplace
+** @description: Writes the accepted replacements to the files (skipping files that changed since indexing) and searches again.
+*/
+func writeReplace(m model) (tea.Model, tea.Cmd) {
+	m = waitSearch(m) // a search that is still streaming holds the index lock that reindexing the written files needs
+	written, errs := index.ApplyReplace(m.replaceHits)
+	accepted := 0
+	for _, hit := range m.replaceHits {
+		if hit.Accepted && coutils.ContainsString(written, hit.Path) {
+			accepted++
+		}
+	}
+	m = closeReplace(m)
+	m.status = fmt.Sprintf("replaced %d lines in %d files", accepted, len(written))
+	for _, err := range errs {
+		m.status += ", skipped " + err.Error()
+	}
+	if err := cobookmarks.Reanchor(); err != nil {
+		m.status += ", bookmarks: " + err.Error()
+	}
+	return searchAgain(m)
+}
+
+/* 
+** @name: KeyReplace
+** @description: Handles the keys while reviewing the changes of a replacement.
+*/
+func KeyReplace(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	hit := &m.replaceHits[m.replaceIndex]
+	switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "esc", "q":
+			m = closeReplace(m)
+			m.status = "replace cancelled, no files were changed"
+			return m, nil
+		case "up", "k", "ctrl+k":
+			m.replaceIndex = (m.replaceIndex - 1 + len(m.replaceHits)) % len(m.replaceHits)
+		case "down", "j", "ctrl+j":
+			m.replaceIndex = (m.replaceIndex + 1) % len(m.replaceHits)
+		case "y", "n":
+			hit.Accepted = msg.String() == "y"
+			m.replaceIndex = min(m.replaceIndex + 1, len(m.replaceHits) - 1)
+		case " ":
+			hit.Accepted = !hit.Accepted
+		case "a", "r":
+			for hitIndex := range m.replaceHits {
+				m.replaceHits[hitIndex].Accepted = msg.String() == "a"
+			}
+		case "enter":
+			m.viewer = coview.NewViewer(coreplace.Absolute(hit.Path), hit.Line)
+		case "w":
+			if coreplace.Accepted(m.replaceHits) == 0 {
+				m.status = "no changes are accepted"
+				break
+			}
+			return writeReplace(m)
+		case "p":
+			patch, errs := index.ReplacePatch(m.replaceHits)
+			m.status = "wrote " + coreplace.PatchFile
+			if err := os.WriteFile(coreplace.PatchFile, []byte(patch), 0644); err != nil {
+				m.status = err.Error()
+			}
+			for _, err := range errs {
+				m.status += ", skipped " + err.Error()
+			}
+	}
+	m.query.Result, m.query.ResultLocations = []string{replaceView(m)}, []string{"replace"}
+	m.resultField.SetValue(m.query.Result[0])
+	return m, nil
+}
+
+/* 
+** @name: KeyBookmark
+** @description: Bookmarks the selected location (in command mode, so a note can be typed first). The file viewer bookmarks the line right away.
+*/
+func KeyBookmark(m model) (tea.Model, tea.Cmd) {
+	filePath, linenumber := selectedLocation(m)
+	if filePath == "" {
+		m.status = "nothing to bookmark, select a result (or type a file id in the explorer)"
+		return m, nil
+	}
+	bookmark := cobookmarks.New(filePath, linenumber)
+	if m.viewer != nil {
+		m.viewer.Message = "bookmarked line " + strconv.Itoa(linenumber)
+		if err := cobookmarks.Add(bookmark); err != nil {
+			m.viewer.Message = err.Error()
+		}
+		return m, nil
+	}
+	m.pendingMark, m.commandMode = &bookmark, true
+	m.queryStyle = QueryStyle(25)
+	m.queryField.SetValue("mark ")
+	m.queryField.Focus()
+	m.queryField.CursorEnd()
+	m.status = "type a note (optional) and press enter to bookmark " + bookmark.Path + ":" + strconv.Itoa(linenumber)
+	return m, nil
+}
+
+/* 
+** @name: KeySimilar
+** @description: Searches for code that is similar to the code around the selected result (or the current line of the file viewer).
+*/
+func KeySimilar(m model) (tea.Model, tea.Cmd) {
+	filePath, linenumber := selectedLocation(m)
+	if filePath == "" {
+		m.status = "nothing selected, select a result (or paste a snippet in similar code search)"
+		return m, nil
+	}
+	path, err := filepath.Rel(index.Root, filePath)
+	if err != nil {
+		path = filePath
+	}
+	m.viewer, m.refining, m.refineSource = nil, false, nil
+	m.indecies.QueryIndex, m.indecies.ResultIndex = 7, 0
+	m.queryStyle = QueryStyle((m.indecies.QueryIndex % 6) + 10)
+	m.query.Query = "@" + path + ":" + strconv.Itoa(linenumber)
+	m.queryField.Reset()
+	return KeyEnterSearch(m)
+}
+
+/* 
+** @name: KeyHistory
+** @description: Replaces the query with an older (step 1) or newer (step -1) query of the same mode that starts with the typed text.
+*/
+func KeyHistory(m model, step int) (tea.Model, tea.Cmd) {
+	if m.historyPosition == 0 {
+		m.historyPrefix = m.queryField.Value()
+	}
+	queries := cohistory.Queries(cohistory.Modes[m.indecies.QueryIndex], m.historyPrefix)
+	m.historyPosition += step
+	if m.historyPosition > len(queries) {
+		m.historyPosition = len(queries)
+	}
+	if m.historyPosition <= 0 {
+		m.historyPosition = 0
+		m.queryField.SetValue(m.historyPrefix)
+	} else {
+		m.queryField.SetValue(queries[m.historyPosition - 1])
+	}
+	m.queryField.Focus()
+	m.queryField.CursorEnd()
+	return m, nil
+}
+
 func KeyEnterForm(m model) (tea.Model, tea.Cmd) {
 	if m.indecies.ContextIndex == 0 {
 		if !coutils.ContainsInt(m.contextCategories, m.indecies.FormIndex) {
@@ -144,16 +852,143 @@ func KeyEnterForm(m model) (tea.Model, tea.Cmd) {
 		} else {
 			m.contextComment = []int{0,1} 
 		}
+	} else if m.indecies.ContextIndex == 2 {
+		if m.contextIncremental[0] == 0 {
+			m.contextIncremental = []int{1,0}
+		} else {
+			m.contextIncremental = []int{0,1} 
+		}
 	}
 	return m, nil
 }
 
+/* 
+** @name: resetIndex
+** @description: Drops the results that point into the previous index (after cd or reindex).
+*/
+func resetIndex(m model) model {
+	rootFiles = codependencies.GetRootFiles()
+	m.results, m.collapsed = []cotypes.Result{}, make(map[string]bool)
+	m.contextCategories, m.indecies.FormIndex, m.indecies.InfoIndex = []int{}, 0, 0
+	m.refining, m.refineSource, m.breadcrumbs = false, nil, nil
+	if err := cobookmarks.Reanchor(); err != nil {
+		m.status = "bookmarks: " + err.Error()
+	}
+	return m
+}
+
+/* 
+** @name: skippedStatus
+** @description: Returns the status line for the paths that were skipped while indexing (one error per line).
+*/
+func skippedStatus(err error) string {
+	return "indexing skipped: " + strings.ReplaceAll(err.Error(), "\n", "; ")
+}
+
+/* 
+** @name: applyOutcome
+** @description: Applies the changes that a command makes to the state of the TUI (directory, index, selected result, viewer).
+*/
+func applyOutcome(m model, outcome cocommands.Outcome) model {
+	m.query.Result, m.query.ResultLocations = outcome.Result, outcome.Locations
+	if outcome.Directory != "" {
+		m = stopSearch(m)
+		directoryIndex, err := engine.Open(outcome.Directory, engine.Options{})
+		if directoryIndex == nil {
+			m.query.Result, m.query.ResultLocations = []string{err.Error()}, []string{"invalid command"}
+			return m
+		}
+		os.Chdir(outcome.Directory)
+		index = directoryIndex
+		m = resetIndex(m)
+		m.lastSearch = nil
+		if err != nil {
+			m.status = skippedStatus(err)
+		}
+		if err := cohistory.Open(outcome.Directory); err != nil {
+			m.status = "history: " + err.Error()
+		}
+		if err := cobookmarks.Open(); err != nil {
+			m.status = "bookmarks: " + err.Error()
+		}
+	} else if outcome.Reindex {
+		m = stopSearch(m)
+		err := index.Reindex()
+		m = resetIndex(m)
+		if err != nil {
+			m.status = skippedStatus(err)
+		}
+	}
+	if outcome.Goto == 0 && outcome.Open == "" {
+		return m
+	}
+	m.commandMode = false
+	m.queryStyle = QueryStyle((m.indecies.QueryIndex % 6) + 10)
+	if outcome.Open != "" {
+		m.viewer = coview.NewViewer(outcome.Open, outcome.Line)
+		if len(m.viewer.Lines) == 0 {
+			m.viewer.Message = "file is not indexed"
+		}
+	} else if len(m.results) > 0 {
+		m.query.Result, m.query.ResultLocations = cosearch.AppendResults([]string{}, []string{}, m.results)
+	}
+	if outcome.Goto > len(m.query.Result) {
+		m.status = "there are only " + strconv.Itoa(len(m.query.Result)) + " results"
+		outcome.Goto = len(m.query.Result)
+	}
+	if outcome.Goto > 0 {
+		m.indecies.ResultIndex = outcome.Goto - 1
+	}
+	return m
+}
+
 func KeyEnterCommand(m model) (tea.Model, tea.Cmd) {
-	m.query.Result, m.query.ResultLocations = cocommands.ParseCommand(m.query.Query)
+	pattern := ""
+	if m.indecies.QueryIndex == 0 && !m.refining {
+		pattern = m.crumb
+	}
+	if fields := strings.Fields(m.query.Query); len(fields) > 0 {
+		command, _ := cocommands.Lookup(fields[0])
+		if (command.Name == "set" && len(fields) > 2) || command.Name == "replace" { // the search reads the settings, and replace plans from its results
+			m = waitSearch(m)
+		}
+	}
+	outcome := cocommands.Run(m.query.Query, cocommands.State{Results: m.results, LastSearch: m.lastSearch, Mark: m.pendingMark, Pattern: pattern, Partial: m.partial, Plan: index.PlanReplace})
+	m.pendingMark, m.status = nil, ""
+	if outcome.Run != nil {
+		return runSaved(m, *outcome.Run)
+	}
+	m = applyOutcome(m, outcome)
+	m = resize(m)
+	if outcome.Picker {
+		m.picker, m.pickerIndex = cohistory.Names(), 0
+		m.query.Result = []string{pickerView(m)}
+	} else if outcome.Marks {
+		m = showMarks(m)
+	} else if outcome.Hits != nil {
+		m.replaceHits, m.replaceIndex = outcome.Hits, 0
+		m.query.Result = []string{replaceView(m)}
+	}
 	m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
 	return m, nil
 }
 
+/* 
+** @name: KeyComplete
+** @description: Completes the command (or its argument) in the query field and lists the candidates.
+*/
+func KeyComplete(m model) (tea.Model, tea.Cmd) {
+	value, candidates := cocommands.Complete(m.queryField.Value())
+	m.queryField.SetValue(value)
+	m.queryField.CursorEnd()
+	if len(candidates) > 1 {
+		m.indecies.ResultIndex = 0
+		m.query.Result, m.query.ResultLocations = []string{"\n" + strings.Join(candidates, "\n")}, []string{"completions"}
+		m.resultField.SetValue(m.query.Result[0])
+	}
+	return m, nil
+}
+
 /* 
 ** @name: KeyEnter 
 ** @description: Runs the selected query. 
@@ -162,6 +997,7 @@ func KeyEnter(m model) (tea.Model, tea.Cmd) {
 	m.indecies.ResultIndex = 0
 	m.query.Query = m.queryField.Value()
 	m.queryField.Reset()
+	m.historyPosition = 0
 	if !m.commandMode && !m.formMode {
 		return KeyEnterSearch(m)
 	} else if m.commandMode && !m.formMode {
@@ -177,6 +1013,9 @@ func KeyEnter(m model) (tea.Model, tea.Cmd) {
 ** @description: Switches to the previous search result. 
 */
 func KeyBack(m model) (tea.Model, tea.Cmd) {
+	if m.groupedView && hasResultList(m) {
+		return KeyGroupStep(m, -1)
+	}
 	m.queryField.Reset()
 	m.resultField.Reset()
 	if m.indecies.ResultIndex > 0 {
@@ -193,6 +1032,9 @@ func KeyBack(m model) (tea.Model, tea.Cmd) {
 ** @description: Switches to the next search result. 
 */
 func KeyForward(m model) (tea.Model, tea.Cmd) {
+	if m.groupedView && hasResultList(m) {
+		return KeyGroupStep(m, 1)
+	}
 	m.queryField.Reset()
 	m.resultField.Reset()
 	m.indecies.ResultIndex = (m.indecies.ResultIndex + 1) % len(m.query.Result)
@@ -205,11 +1047,16 @@ func KeyForward(m model) (tea.Model, tea.Cmd) {
 ** @description: Switches to the next search function.
 */
 func KeyTab(m model) (tea.Model, tea.Cmd) {
-	if !m.commandMode && !m.formMode {
+	if m.refining {
+		m.indecies.QueryIndex = 1 - m.indecies.QueryIndex
+		m.queryStyle = QueryStyle((m.indecies.QueryIndex % 6) + 10)
+	} else if !m.commandMode && !m.formMode {
 		m.indecies.QueryIndex = (m.indecies.QueryIndex + 1) % len(m.query.QueryType)
-		m.queryStyle = QueryStyle((m.indecies.QueryIndex % 5) + 10)
+		m.queryStyle = QueryStyle((m.indecies.QueryIndex % 6) + 10)
 	} else if m.formMode {
-		m.indecies.ContextIndex = (m.indecies.ContextIndex + 1) % 2 
+		m.indecies.ContextIndex = (m.indecies.ContextIndex + 1) % 3 
+	} else if m.commandMode {
+		return KeyComplete(m)
 	}
 	return m, nil
 }
@@ -222,7 +1069,7 @@ func KeyCtrlG(m model) (tea.Model, tea.Cmd) {
 	categoryContext := coutils.SubsetSlice(coparse.ContextCategories, m.contextCategories)
 	if m.indecies.QueryIndex == 2 {
 		m.indecies.InfoIndex = (m.indecies.InfoIndex + 1) % len(coparse.InfoBoxCategories) 
-		m.query.Result, m.query.ResultLocations = coexplore.Show(fullTree, 0, 5, m.query.Query, m.viewDirOnly, m.indecies.InfoIndex)
+		m.query.Result, m.query.ResultLocations = coexplore.Show(index.FullTree(), 0, coexplore.MaxDepth, m.query.Query, m.viewDirOnly, m.indecies.InfoIndex)
 		m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
 	} else if m.indecies.QueryIndex == 3 {
 		m.indecies.InfoIndex = (m.indecies.InfoIndex + 1) % len(coparse.InfoBoxCategories)
@@ -243,7 +1090,7 @@ func KeyCtrlG(m model) (tea.Model, tea.Cmd) {
 func KeyToggleDir(m model) (tea.Model, tea.Cmd) {
 	if m.indecies.QueryIndex == 2 {
 		m.viewDirOnly = !m.viewDirOnly
-		m.query.Result, m.query.ResultLocations = coexplore.Show(fullTree, 0, 5, m.query.Query, m.viewDirOnly, m.indecies.InfoIndex)
+		m.query.Result, m.query.ResultLocations = coexplore.Show(index.FullTree(), 0, coexplore.MaxDepth, m.query.Query, m.viewDirOnly, m.indecies.InfoIndex)
 		m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
 	}
 	return m, nil
@@ -271,11 +1118,290 @@ func KeyDown(m model) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+/* 
+** @name: KeyOpenViewer
+** @description: Opens the selected search result (or file) in the file viewer at the matched line.
+*/
+func KeyOpenViewer(m model) (tea.Model, tea.Cmd) {
+	if m.commandMode || m.formMode {
+		return m, nil
+	} else if hasResultList(m) {
+		result := m.results[m.indecies.ResultIndex]
+		m.viewer = coview.NewViewer(coparse.OrderedKeys[result.Index].FilePath, result.Line)
+	} else if m.indecies.QueryIndex == 4 {
+		for _, key := range coparse.OrderedKeys {
+			if key.Filename == m.query.ResultLocations[m.indecies.ResultIndex] {
+				m.viewer = coview.NewViewer(key.FilePath, 1)
+				break
+			}
+		}
+	}
+	return m, nil
+}
+
+/* 
+** @name: selectedLocation
+** @description: Returns the file and line of the selected result, viewer line or (explorer) file id in the query field.
+*/
+func selectedLocation(m model) (string, int) {
+	if m.viewer != nil {
+		return m.viewer.FilePath, m.viewer.Cursor + 1
+	} else if m.commandMode || m.formMode {
+		return "", 0
+	} else if hasResultList(m) {
+		result := m.results[m.indecies.ResultIndex]
+		return coparse.OrderedKeys[result.Index].FilePath, result.Line
+	} else if m.indecies.QueryIndex == 4 {
+		for _, key := range coparse.OrderedKeys {
+			if key.Filename == m.query.ResultLocations[m.indecies.ResultIndex] {
+				return key.FilePath, 1
+			}
+		}
+	} else if id, err := strconv.Atoi(strings.TrimSpace(m.queryField.Value())); err == nil && m.indecies.QueryIndex == 2 {
+		if filePath := coexplore.SelectPath(index.FullTree(), id); filePath != "" {
+			if info, err := os.Stat(filePath); err == nil && !info.IsDir() {
+				return filePath, 1
+			}
+		}
+	}
+	return "", 0
+}
+
+/* 
+** @name: KeyOpenEditor
+** @description: Suspends the TUI and opens the selected location in $VISUAL/$EDITOR.
+*/
+func KeyOpenEditor(m model) (tea.Model, tea.Cmd) {
+	filePath, linenumber := selectedLocation(m)
+	if filePath == "" {
+		m.status = "nothing to open, select a result (or type a file id in the explorer)"
+		return m, nil
+	}
+	return openEditor(m, filePath, linenumber)
+}
+
+/* 
+** @name: openEditor
+** @description: Suspends the TUI and opens a file at a line in $VISUAL/$EDITOR.
+*/
+func openEditor(m model, filePath string, linenumber int) (tea.Model, tea.Cmd) {
+	cmd, err := coeditor.Command(filePath, linenumber)
+	if err != nil {
+		m.status = err.Error()
+		return m, nil
+	}
+	m = stopSearch(m)
+	return m, tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return editorDoneMsg{filePath, err}
+	})
+}
+
+/* 
+** @name: editorDone
+** @description: Re-indexes the edited file and refreshes the viewer/results that show it.
+*/
+func editorDone(m model, msg editorDoneMsg) (tea.Model, tea.Cmd) {
+	m.status = ""
+	if msg.err != nil {
+		m.status = "editor: " + msg.err.Error()
+	}
+	m = waitSearch(m)
+	index.ReindexFile(msg.filePath)
+	if err := cobookmarks.Reanchor(); err != nil {
+		m.status = "bookmarks: " + err.Error()
+	}
+	if m.marks {
+		m = showMarks(m)
+		m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+	}
+	if m.viewer != nil {
+		cursor, search := m.viewer.Cursor, m.viewer.Search
+		m.viewer = coview.NewViewer(m.viewer.FilePath, cursor + 1)
+		m.viewer.Search, m.viewer.Message = search, m.status
+	}
+	return searchAgain(m)
+}
+
+/* 
+** @name: searchAgain
+** @description: Runs the shown search again after files changed (refinements are dropped, they point to the old lines).
+*/
+func searchAgain(m model) (tea.Model, tea.Cmd) {
+	if m.refining || len(m.breadcrumbs) > 0 { // the refined results point to the old lines, so start over from the first search
+		if len(m.breadcrumbs) > 0 {
+			m.query.Query, m.indecies.QueryIndex = m.breadcrumbs[0].query, m.breadcrumbs[0].queryIndex
+		} else {
+			m.query.Query, m.indecies.QueryIndex = m.crumb, m.refineMode
+		}
+		m.queryStyle = QueryStyle((m.indecies.QueryIndex % 6) + 10)
+		m.refining, m.refineSource, m.breadcrumbs, m.crumb = false, nil, nil, m.query.Query
+		m.status = strings.TrimSpace(m.status + " the refinements were dropped because the file changed")
+	}
+	if hasResultList(m) { // the indexes of the results changed, so search again
+		m.indecies.ResultIndex = 0
+		return startSearch(m)
+	}
+	return m, nil
+}
+
+/* 
+** @name: KeyViewerPrompt
+** @description: Handles the keys while typing a search pattern (/) or line number (:) in the file viewer.
+*/
+func KeyViewerPrompt(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch msg.String() {
+		case "esc":
+			m.viewerPrompt = ""
+			m.queryField.Reset()
+			return m, nil
+		case "enter":
+			if m.viewerPrompt == "/" {
+				m.viewer.Find(m.queryField.Value(), true)
+			} else if linenumber, err := strconv.Atoi(strings.TrimSpace(m.queryField.Value())); err == nil {
+				m.viewer.Goto(linenumber)
+				m.viewer.Message = ""
+			} else {
+				m.viewer.Message = "invalid line number"
+			}
+			m.viewerPrompt = ""
+			m.queryField.Reset()
+			return m, nil
+	}
+	m.queryField, cmd = m.queryField.Update(msg)
+	return m, cmd
+}
+
+/* 
+** @name: KeyViewer
+** @description: Handles the keys while the file viewer is open (scrolling, jumping and searching).
+*/
+func KeyViewer(m model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.viewerPrompt != "" {
+		return KeyViewerPrompt(m, msg)
+	}
+	_, height := layoutSize(m)
+	switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "esc", "q":
+			m.viewer = nil
+		case "up", "k":
+			m.viewer.Move(-1)
+		case "down", "j":
+			m.viewer.Move(1)
+		case "pgup", "ctrl+u":
+			m.viewer.Move(-height)
+		case "pgdown", "ctrl+d", " ":
+			m.viewer.Move(height)
+		case "g", "home":
+			m.viewer.Goto(1)
+		case "G", "end":
+			m.viewer.Goto(len(m.viewer.Lines))
+		case "n":
+			m.viewer.Find("", true)
+		case "N":
+			m.viewer.Find("", false)
+		case "e":
+			return KeyOpenEditor(m)
+		case "b":
+			return KeyBookmark(m)
+		case "s":
+			return KeySimilar(m)
+		case "/", ":":
+			m.viewerPrompt = msg.String()
+			m.queryField.Reset()
+			m.queryField.Focus()
+	}
+	return m, nil
+}
+
+/* 
+** @name: selectedGroup
+** @description: Returns the position of the file group that contains the selected result.
+*/
+func selectedGroup(m model, groups []cotypes.FileGroup) int {
+	for position, group := range groups {
+		if coutils.ContainsInt(group.Hits, m.indecies.ResultIndex) {
+			return position
+		}
+	}
+	return 0
+}
+
+/* 
+** @name: KeyToggleGrouped
+** @description: Switches between the result list and the results grouped by file.
+*/
+func KeyToggleGrouped(m model) (tea.Model, tea.Cmd) {
+	m.groupedView = !m.groupedView
+	return m, nil
+}
+
+/* 
+** @name: KeyGroupStep
+** @description: Selects the next/previous hit in the grouped view (collapsed files count as one hit).
+*/
+func KeyGroupStep(m model, step int) (tea.Model, tea.Cmd) {
+	groups := cosearch.GroupResults(m.results)
+	hits, position := []int{}, 0
+	for _, group := range groups {
+		if coutils.ContainsInt(group.Hits, m.indecies.ResultIndex) {
+			position = len(hits)
+		}
+		if m.collapsed[group.Path] {
+			hits = append(hits, group.Hits[0])
+			continue
+		}
+		for _, hit := range group.Hits {
+			if hit == m.indecies.ResultIndex {
+				position = len(hits)
+			}
+			hits = append(hits, hit)
+		}
+	}
+	m.indecies.ResultIndex = hits[(position + step + len(hits)) % len(hits)]
+	m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+	return m, nil
+}
+
+/* 
+** @name: KeyGroupFile
+** @description: Jumps to the first hit of the next/previous file in the grouped view.
+*/
+func KeyGroupFile(m model, step int) (tea.Model, tea.Cmd) {
+	if !m.groupedView || !hasResultList(m) {
+		return m, nil
+	}
+	groups := cosearch.GroupResults(m.results)
+	position := (selectedGroup(m, groups) + step + len(groups)) % len(groups)
+	m.indecies.ResultIndex = groups[position].Hits[0]
+	m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+	return m, nil
+}
+
+/* 
+** @name: KeyCollapse
+** @description: Collapses (or expands) the file of the selected hit in the grouped view.
+*/
+func KeyCollapse(m model) (tea.Model, tea.Cmd) {
+	if !m.groupedView || !hasResultList(m) {
+		return m, nil
+	}
+	groups := cosearch.GroupResults(m.results)
+	group := groups[selectedGroup(m, groups)]
+	m.collapsed[group.Path] = !m.collapsed[group.Path]
+	m.indecies.ResultIndex = group.Hits[0]
+	m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
+	return m, nil
+}
+
 /* 
 ** @name: KeyColon
 ** @description: Switches to command mode 
 */
 func KeyColon(m model) (tea.Model, tea.Cmd) {
+	m = stopSearch(m) // its results would overwrite the command line, picker, bookmarks or replace view
 	m.indecies.ResultIndex = 0
 	m.query.Result, m.query.ResultLocations = []string{""}, []string{"None"} 
 	m.resultField.SetValue(m.query.Result[m.indecies.ResultIndex])
@@ -283,7 +1409,7 @@ func KeyColon(m model) (tea.Model, tea.Cmd) {
 	if m.commandMode {
 		m.queryStyle = QueryStyle(25)
 	} else {
-		m.queryStyle = QueryStyle((m.indecies.QueryIndex % 5) + 10)
+		m.queryStyle = QueryStyle((m.indecies.QueryIndex % 6) + 10)
 	}
 	m.queryField.Focus()
 	return m, nil
@@ -299,15 +1425,37 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case tea.WindowSizeMsg:
 			m.width = msg.Width
 			m.height = msg.Height
+			return resize(m), nil
+		case searchResultsMsg:
+			return receiveResults(m, msg)
+		case searchDoneMsg:
+			return finishSearch(m, msg)
+		case debounceMsg:
+			return searchAsYouType(m, msg)
+		case editorDoneMsg:
+			return editorDone(m, msg)
 		case tea.KeyMsg:
+			if m.viewer != nil {
+				return KeyViewer(m, msg)
+			} else if m.picker != nil {
+				return KeyPicker(m, msg)
+			} else if m.marks {
+				return KeyMarks(m, msg)
+			} else if m.replaceHits != nil {
+				return KeyReplace(m, msg)
+			}
 			switch msg.String() {
 				case "ctrl+c":
 					return m, tea.Quit
 				case "/":
-					m.queryField.Focus()
-					return m, nil
+					if !m.queryField.Focused() || m.queryField.Value() == "" {
+						m.queryField.Focus()
+						return m, nil
+					}
 				case ":":
-					return KeyColon(m)
+					if !m.queryField.Focused() || m.queryField.Value() == "" {
+						return KeyColon(m)
+					}
 				case "esc":
 					return KeyEscape(m)
 				case "enter":
@@ -319,8 +1467,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				case "ctrl+k":
 					return KeyForward(m)
 				case "up": 
+					if !m.formMode && !m.commandMode {
+						return KeyHistory(m, 1)
+					}
 					return KeyDown(m)
 				case "down": 
+					if !m.formMode && !m.commandMode {
+						return KeyHistory(m, -1)
+					}
 					return KeyUp(m)
 				case "tab":
 					return KeyTab(m)
@@ -328,9 +1482,38 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return KeyCtrlG(m)
 				case "ctrl+f":
 					return KeyCtrlF(m)
+				case "ctrl+o":
+					return KeyOpenViewer(m)
+				case "ctrl+e":
+					return KeyOpenEditor(m)
+				case "ctrl+t":
+					return KeyToggleGrouped(m)
+				case "ctrl+n":
+					return KeyGroupFile(m, 1)
+				case "ctrl+p":
+					return KeyGroupFile(m, -1)
+				case "ctrl+y":
+					return KeyCollapse(m)
+				case "ctrl+b":
+					return KeyBookmark(m)
+				case "ctrl+r":
+					return KeyRefine(m)
+				case "ctrl+x":
+					return KeyPopRefinement(m)
+				case "ctrl+s":
+					return KeySimilar(m)
 			}
 		}
+	previousQuery := m.queryField.Value()
 	m.queryField, cmd = m.queryField.Update(msg)
+	if m.queryField.Value() != previousQuery {
+		m.historyPosition = 0
+	}
+	incrementalMode := m.indecies.QueryIndex == 0 || m.indecies.QueryIndex == 1 || m.indecies.QueryIndex == 5 || m.indecies.QueryIndex == 6 || m.indecies.QueryIndex == 7
+	if m.contextIncremental[0] == 1 && incrementalMode && !m.commandMode && !m.formMode && m.queryField.Value() != previousQuery {
+		m.typeId += 1
+		return m, tea.Batch(cmd, debounce(m.typeId))
+	}
 	return m, cmd	
 }
 
@@ -369,15 +1552,243 @@ func formViewComment(m model) string {
 	return s.String()
 }
 
+func formViewIncremental(m model) string {
+	incrementalCategories := []string{"on", "off (recommended for very large repositories)"}
+	s := strings.Builder{}
+	s.WriteString("\n\t3   Search as you type (quick, fuzzy and symbol search):\n\n")
+	for i := 0; i < len(incrementalCategories); i++ {
+		if m.indecies.FormIndex == i && m.indecies.ContextIndex == 2 {
+			s.WriteString("\t[X] ")
+		} else if m.contextIncremental[i] == 1 {
+			s.WriteString("\t[x] ")	
+		} else {
+			s.WriteString("\t[ ] ")
+		}
+		s.WriteString(incrementalCategories[i] + "\n")
+	}
+	return s.String()
+}
+
 func formView(m model) string { 
 	s := strings.Builder{}
 	s.WriteString(formViewCategories(m))
 	s.WriteString(formViewComment(m))
+	s.WriteString(formViewIncremental(m))
 	s.WriteString("\n\tpress ctrl+c to quit | press ctrl+f to return | press enter to submit choice | tab to switch \n")
 	return s.String()
 }
 
+/* 
+** @name: searchStatus
+** @description: Returns the progress of a background search for the status line.
+*/
+func searchStatus(m model) string {
+	if m.searching {
+		return " | searching, " + strconv.Itoa(len(m.results)) + " results so far (esc to cancel)"
+	} else if m.status != "" {
+		return " | " + m.status
+	}
+	return ""
+}
+
+/* 
+** @name: layoutSize
+** @description: Returns the width and height of the result box based on the terminal size.
+*/
+func layoutSize(m model) (int, int) {
+	width, height := 100, 20
+	if cosettings.BoxWidth > 0 {
+		width = cosettings.BoxWidth
+	} else if m.width >= 64 {
+		width = m.width - 4
+	}
+	if m.height >= 30 {
+		height = m.height - 16
+	}
+	return width, height
+}
+
+/* 
+** @name: hasResultList
+** @description: Returns true if the current results can be shown as a list with a preview.
+*/
+func hasResultList(m model) bool {
+	searchMode := m.indecies.QueryIndex == 0 || m.indecies.QueryIndex == 1 || m.indecies.QueryIndex == 5 || m.indecies.QueryIndex == 6 || m.indecies.QueryIndex == 7
+	return searchMode && !m.commandMode && len(m.results) > 0
+}
+
+/* 
+** @name: renderMatches
+** @description: Renders a line with its matched characters highlighted.
+*/
+func renderMatches(line string, mask []bool, baseStyle lipgloss.Style) string {
+	highlightStyle := baseStyle.Copy().Bold(true).Foreground(lipgloss.Color("11"))
+	s := strings.Builder{}
+	runes := []rune(line)
+	for start := 0; start < len(runes); {
+		end := start
+		for end < len(runes) && mask[end] == mask[start] {
+			end += 1
+		}
+		if mask[start] {
+			s.WriteString(highlightStyle.Render(string(runes[start:end])))
+		} else {
+			s.WriteString(baseStyle.Render(string(runes[start:end])))
+		}
+		start = end
+	}
+	return s.String()
+}
+
+/* 
+** @name: listView
+** @description: Returns the scrollable list of result locations (the selected one is highlighted).
+*/
+func listView(m model, width int, height int) string {
+	start := m.indecies.ResultIndex - height/2
+	if start > len(m.results) - height {
+		start = len(m.results) - height
+	}
+	if start < 0 {
+		start = 0
+	}
+	rows := []string{}
+	for i := start; i < len(m.results) && i < start + height; i++ {
+		baseStyle := lipgloss.NewStyle()
+		if i == m.indecies.ResultIndex {
+			baseStyle = baseStyle.Reverse(true)
+		}
+		location, text, matches := cosearch.ListEntry(m.results[i])
+		location = coutils.CropRunes(location, width)
+		text, mask := coutils.CropAround(text, matches, width - len([]rune(location)))
+		padding := strings.Repeat(" ", width - len([]rune(location)) - len([]rune(text)))
+		rows = append(rows, baseStyle.Render(location) + renderMatches(text, mask, baseStyle) + baseStyle.Render(padding))
+	}
+	return strings.Join(rows, "\n")
+}
+
+/* 
+** @name: previewView
+** @description: Returns the lines around the selected result (with the matches highlighted and the lines outside the context dimmed).
+*/
+func previewView(m model, width int, height int) string {
+	result := m.results[m.indecies.ResultIndex]
+	prefixes, lines, hitRow := cosearch.PreviewLines(result, height)
+	rows := []string{}
+	for row, line := range lines {
+		prefix := coutils.CropRunes(prefixes[row], width)
+		matches := [][2]int{}
+		if row == hitRow {
+			matches = cosearch.ZeroBasedMatches(result)
+		}
+		baseStyle := lipgloss.NewStyle()
+		if row < hitRow - cosearch.ContextBefore || row > hitRow + cosearch.ContextAfter {
+			baseStyle = baseStyle.Faint(true)
+		}
+		text, mask := coutils.CropAround(line, matches, width - len([]rune(prefix)))
+		rows = append(rows, baseStyle.Render(prefix) + renderMatches(text, mask, baseStyle))
+	}
+	return strings.Join(rows, "\n")
+}
+
+/* 
+** @name: groupedView
+** @description: Returns the results grouped by file (a header with the hit count, followed by the merged snippets).
+*/
+func groupedView(m model, width int, height int) string {
+	headerStyle := lipgloss.NewStyle().Bold(true)
+	rows, selectedRow := []string{}, 0
+	for position, group := range cosearch.GroupResults(m.results) {
+		marker, hits := "▾ ", " hits)"
+		if m.collapsed[group.Path] {
+			marker = "▸ "
+		}
+		if len(group.Hits) == 1 {
+			hits = " hit)"
+		}
+		style := headerStyle
+		if m.collapsed[group.Path] && coutils.ContainsInt(group.Hits, m.indecies.ResultIndex) {
+			style, selectedRow = headerStyle.Copy().Reverse(true), len(rows)
+		}
+		if position > 0 {
+			rows = append(rows, "")
+		}
+		rows = append(rows, style.Render(coutils.CropRunes(marker + group.Path + " (" + strconv.Itoa(len(group.Hits)) + hits, width)))
+		if m.collapsed[group.Path] {
+			continue
+		}
+		for number, snippet := range group.Snippets {
+			if number > 0 {
+				rows = append(rows, lipgloss.NewStyle().Faint(true).Render("  ···"))
+			}
+			for i := snippet.Start; i <= snippet.End; i++ {
+				key := coparse.OrderedKeys[i]
+				prefix := "  " + strconv.Itoa(key.Linenumber) + coutils.ResponsiveTab(strconv.Itoa(key.Linenumber)) + "|  "
+				baseStyle, matches := lipgloss.NewStyle(), [][2]int{}
+				for _, hit := range snippet.Hits {
+					if m.results[hit].Index == i {
+						prefix, matches = strings.Replace(prefix, "|", ">", 1), cosearch.ZeroBasedMatches(m.results[hit])
+						if hit == m.indecies.ResultIndex {
+							baseStyle, selectedRow = baseStyle.Reverse(true), len(rows)
+						}
+					}
+				}
+				text, mask := coutils.CropAround(coparse.LabeledRows[key], matches, width - len([]rune(prefix)))
+				rows = append(rows, baseStyle.Render(prefix) + renderMatches(text, mask, baseStyle))
+			}
+		}
+	}
+	start := selectedRow - height/2
+	if start > len(rows) - height {
+		start = len(rows) - height
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + height
+	if end > len(rows) {
+		end = len(rows)
+	}
+	return strings.Join(rows[start:end], "\n")
+}
+
+/* 
+** @name: groupStatus
+** @description: Returns the position of the selected file in the grouped view for the status line.
+*/
+func groupStatus(m model) string {
+	if !m.groupedView || !hasResultList(m) {
+		return ""
+	}
+	groups := cosearch.GroupResults(m.results)
+	return " | file " + strconv.Itoa(selectedGroup(m, groups) + 1) + "/" + strconv.Itoa(len(groups))
+}
+
+/* 
+** @name: splitView
+** @description: Returns the result list (left) next to the preview of the selected result (right).
+*/
+func splitView(m model) string {
+	width, height := layoutSize(m)
+	listWidth := width * 2 / 5
+	previewWidth := width - listWidth - 2
+	paneStyle := lipgloss.NewStyle().BorderForeground(m.resultStyle.BorderColor).BorderStyle(lipgloss.NormalBorder()).Padding(0, 1).Height(height + 2)
+	return lipgloss.JoinHorizontal(
+		lipgloss.Top,
+		paneStyle.Copy().Width(listWidth).Render(listView(m, listWidth - 2, height + 2)),
+		paneStyle.Copy().Width(previewWidth).Render(previewView(m, previewWidth - 2, height + 2)),
+	)
+}
+
 func searchView(m model, title string) string {
+	width, height := layoutSize(m)
+	resultBox := m.resultStyle.InputField.Copy().Width(width).Height(height).Render(m.resultField.View())
+	if hasResultList(m) && m.groupedView {
+		paneStyle := lipgloss.NewStyle().BorderForeground(m.resultStyle.BorderColor).BorderStyle(lipgloss.NormalBorder()).Padding(0, 1)
+		resultBox = paneStyle.Width(width).Height(height + 2).Render(groupedView(m, width - 2, height + 2))
+	} else if hasResultList(m) {
+		resultBox = splitView(m)
+	}
   return lipgloss.Place(
   	m.width,
   	m.height,
@@ -388,11 +1799,11 @@ func searchView(m model, title string) string {
 			lipgloss.JoinVertical(
 				lipgloss.Left,
 				title,
-				m.queryStyle.InputField.Render(m.queryField.View()),
+				m.queryStyle.InputField.Copy().Width(width).Render(m.queryField.View()),
 			),
 			lipgloss.JoinVertical(
 				lipgloss.Left,
-				m.resultStyle.InputField.Render(m.resultField.View()),
+				resultBox,
 				lipgloss.JoinHorizontal(
 					lipgloss.Left,
 					m.query.ResultLocations[m.indecies.ResultIndex],
@@ -400,6 +1811,8 @@ func searchView(m model, title string) string {
 					strconv.Itoa(m.indecies.ResultIndex+1),
 					"/",
 					strconv.Itoa(len(m.query.Result)),
+					groupStatus(m),
+					searchStatus(m),
 					" | press ctrl+c to quit | press ctrl+f for settings",
 				),
 			),
@@ -407,16 +1820,44 @@ func searchView(m model, title string) string {
 	)
 }
 
+/* 
+** @name: viewerView
+** @description: Returns the layout of the file viewer (prompt, highlighted file and status line).
+*/
+func viewerView(m model) string {
+	width, height := layoutSize(m)
+	prompt := "press / to search, : to jump to a line, n/N for the next/previous match, e to edit, b to bookmark, s for similar code"
+	if m.viewerPrompt != "" {
+		prompt = m.viewerPrompt + m.queryField.Value()
+	}
+	fileStyle := lipgloss.NewStyle().BorderForeground(m.resultStyle.BorderColor).BorderStyle(lipgloss.NormalBorder()).Padding(0, 1).Width(width).Height(height + 2)
+	return lipgloss.Place(
+		m.width,
+		m.height,
+		lipgloss.Center,
+		lipgloss.Top,
+		lipgloss.JoinVertical(
+			lipgloss.Left,
+			"File viewer",
+			m.queryStyle.InputField.Copy().Width(width).Render(prompt),
+			fileStyle.Render(m.viewer.View(width - 2, height + 2)),
+			m.viewer.Status() + " | press esc to close",
+		),
+	)
+}
+
 /* 
 ** @name: View
 ** @description: Returns the layout/placement of the visual elements of the TUI.
 */
 func (m model) View() string {
-	title := m.query.QueryType[m.indecies.QueryIndex]
+	title := m.query.QueryType[m.indecies.QueryIndex] + breadcrumbTrail(m)
 	if m.commandMode {
 		title = "command mode"
 	}
-	if m.formMode {
+	if m.viewer != nil {
+		return viewerView(m)
+	} else if m.formMode {
 		return formView(m) 
 	} else {
 		return searchView(m, title)
@@ -426,11 +1867,33 @@ func (m model) View() string {
 // runner function
 
 func main() {
-	fmt.Println(coparse.Imports, rootFiles)
+	if len(os.Args) > 1 {
+		os.Exit(cocli.Run(os.Args[1:]))
+	}
+	configErrors := cosettings.LoadConfig(coparse.CurrentDirectory)
+	var err error
+	if index, err = engine.Open(coparse.CurrentDirectory, engine.Options{Verbose: true}); index == nil {
+		log.Fatal(err)
+	}
+	indexErr := err
+	index.Options.Verbose = false
+	rootFiles = codependencies.GetRootFiles()
 	fmt.Println("Codis (alpha version). Last updated: January 2024 By Timo Kats")
-	queryTypes := []string{"Quick search", "Fuzzy search", "Explorative search", "Dependency search", "File view"}
-	query := cotypes.Query{"", []string{"None"}, []string{"None"}, queryTypes}
+	queryTypes := []string{"Quick search", "Fuzzy search", "Explorative search", "Dependency search", "File view", "Symbol search", "Structural search", "Similar code"}
+	query := cotypes.Query{Query: "", Result: []string{"None"}, ResultLocations: []string{"None"}, QueryType: queryTypes}
 	m := New(query)
+	for _, err := range configErrors {
+		m.status += err.Error() + " "
+	}
+	if indexErr != nil {
+		m.status += skippedStatus(indexErr) + " "
+	}
+	if err := cohistory.Open(index.Root); err != nil {
+		m.status += "history: " + err.Error() + " "
+	}
+	if err := cobookmarks.Open(); err != nil {
+		m.status += "bookmarks: " + err.Error()
+	}
 	p := tea.NewProgram(m, tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
 		log.Fatal(err)